@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"craftDemoClient/format/tableFormat"
 	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
+	"model"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
@@ -21,34 +25,63 @@ const (
 	NumGoRoutines = 10 // maximum number of go routines to fan out
 )
 
-// Incidents Json structure
-type Incidents struct {
-	Name   string     `json:"Name"`
-	Report []Incident `json:"Report"`
-}
-
-// Individual inc structure
-type Incident struct {
-	Number      string `json:"number"`
-	AssignedTo  string `json:"assigned_to"`
-	Description string `json:"description"`
-	State       string `json:"state"`
-	Priority    string `json:"priority"`
-	Severity    string `json:"severity"`
-}
-
 // Aggregated report structure based on priority
 type PrioritySum struct {
 	Priority string
 	Sum      int
 }
 
+// Last 200 response of a url along with its validators
+// It is used to answer 304 Not Modified from the server
+type cachedResponse struct {
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+}
+
+// One page of the list response, Next links to the following page if there is one
+type incidentsPage struct {
+	model.Incidents
+	Next string `json:"next"`
+}
+
+// Json error returned by the server for failed requests
+type APIError struct {
+	Status    int                    `json:"-"`
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id"`
+	Details   map[string]interface{} `json:"details"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("server returned %d %s: %s", e.Status, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// api key or bearer token sent along with every request, if set
+var authToken string
+
+// tls config of the requests, main sets it up out of the config
+var tlsConfig = &tls.Config{}
+
+// responses cached by url, guarded by cacheMu
+var (
+	cacheMu       sync.Mutex
+	responseCache = make(map[string]*cachedResponse)
+)
+
 // Initialize httpclient and request the given url
 // Retry 5 times, while connecting to the server incase of error
+// Responses carrying an ETag or Last-Modified are cached, the next request of the
+// same url is made conditional and a 304 is answered from the cache
 func GetResponse(url string) (res *http.Response, err error) {
-	// InsecureSkipVerify to false for production
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
 	client := http.Client{
 		Transport: tr,
@@ -58,6 +91,21 @@ func GetResponse(url string) (res *http.Response, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+
+	cacheMu.Lock()
+	cached := responseCache[url]
+	cacheMu.Unlock()
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
 
 	retry := RETRY
 	for i := 1; i <= retry; i++ {
@@ -73,22 +121,97 @@ func GetResponse(url string) (res *http.Response, err error) {
 			retry = 0
 		}
 	}
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		log.Debug("Not modified, using cached response of ", url)
+		res.Body.Close()
+		return cached.response(req), nil
+	}
+
+	if res.StatusCode == http.StatusOK {
+		etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			body, readErr := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if readErr != nil {
+				return nil, readErr
+			}
+			res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			cacheMu.Lock()
+			responseCache[url] = &cachedResponse{ETag: etag, LastModified: lastModified, Header: res.Header, Body: body}
+			cacheMu.Unlock()
+		}
+	}
 	return res, nil
 }
 
+// response rebuilds the cached 200 response for req
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// loadCache fills the response cache from file, it is fine if the file does not exist
+func loadCache(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cache := make(map[string]*cachedResponse)
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("cache file %s: %v", file, err)
+	}
+	cacheMu.Lock()
+	responseCache = cache
+	cacheMu.Unlock()
+	return nil
+}
+
+// saveCache writes the response cache to file, readable only by the user as it holds api responses
+func saveCache(file string) error {
+	cacheMu.Lock()
+	data, err := json.Marshal(responseCache)
+	cacheMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
 // Validate the response based on response header
 func ValidateResponse(res *http.Response) (err error) {
 	var resLength int
 	// non 200 errors
 	if res.StatusCode != 200 {
-		err = fmt.Errorf("Received %d status code\n", res.StatusCode)
+		err = decodeError(res)
 	} else if res.Header["Content-Type"][0] != "application/json" {
 		err = fmt.Errorf("Content type not spplication/json. Received => %s\n", res.Header["Content-Type"][0])
 	} else {
 		if len(res.Header["Content-Length"]) > 0 {
 			resLength, err = strconv.Atoi(res.Header["Content-Length"][0])
-			if err == nil && resLength != 905 {
-				err = fmt.Errorf("content-Length mismatch 905 vs %d\n", resLength)
+			if err == nil {
+				// the report size depends on the query, so compare with the received body
+				// put the body back so that ParseBody can read it
+				var body []byte
+				body, err = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				res.Body = ioutil.NopCloser(bytes.NewReader(body))
+				if err == nil && resLength != len(body) {
+					err = fmt.Errorf("content-Length mismatch %d vs %d\n", resLength, len(body))
+				}
 			}
 		}
 	}
@@ -98,13 +221,13 @@ func ValidateResponse(res *http.Response) (err error) {
 // walkIncs will walk through slice of incidents and sends required priority details
 // to outbound channel. Once slice values are exhausted, close the output channel
 // If done signal received, return early
-func walkIncs(ctx context.Context, report []Incident) (chan map[string]int, error) {
+func walkIncs(ctx context.Context, report []model.Incident) (chan map[string]int, error) {
 	out := make(chan map[string]int)
 	go func() {
 		defer close(out)
 		for _, obj := range report {
 			m := make(map[string]int)
-			m[obj.Priority] = 1
+			m[string(obj.Priority)] = 1
 			select {
 			case out <- m:
 			case <-ctx.Done():
@@ -144,7 +267,7 @@ func mergeIncs(ctx context.Context, incs chan map[string]int, out chan map[strin
 // Fan out that channel to bounded go routines. This will merge the values and
 // send to single output channel
 // We can have any levels of merging depending on load
-func GenerateAggReportPriority(report []Incident) (sum *[]PrioritySum, err error) {
+func GenerateAggReportPriority(report []model.Incident) (sum *[]PrioritySum, err error) {
 
 	// create context with cancel to inform goroutines to exit
 	ctx, cancel := context.WithCancel(context.Background())
@@ -197,7 +320,30 @@ func GenerateAggReportPriority(report []Incident) (sum *[]PrioritySum, err error
 	return &sumObj, nil
 }
 
-func ParseBody(res *http.Response) (*Incidents, error) {
+// decodeError returns the json error of a failed response as *APIError
+// Responses without one, e.g. from a proxy, only report the status code
+// The body is put back for the caller
+func decodeError(res *http.Response) error {
+	apiErr := &APIError{Status: res.StatusCode}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil || json.Unmarshal(body, apiErr) != nil || apiErr.Code == "" {
+		return fmt.Errorf("Received %d status code\n", res.StatusCode)
+	}
+	return apiErr
+}
+
+func ParseBody(res *http.Response) (*model.Incidents, error) {
+	page, err := parsePage(res)
+	if err != nil {
+		return nil, err
+	}
+	return &page.Incidents, nil
+}
+
+// parsePage decodes the body into one page of the list response
+func parsePage(res *http.Response) (*incidentsPage, error) {
 	body, readErr := ioutil.ReadAll(res.Body)
 	if readErr != nil {
 		return nil, readErr
@@ -205,12 +351,68 @@ func ParseBody(res *http.Response) (*Incidents, error) {
 	defer res.Body.Close()
 
 	// encode the body into json with given incidents struct
-	var incidents Incidents
-	jsonErr := json.Unmarshal(body, &incidents)
+	var page incidentsPage
+	jsonErr := json.Unmarshal(body, &page)
 	if jsonErr != nil {
 		return nil, jsonErr
 	}
-	return &incidents, nil
+
+	// received incidents are checked against the model, like the server checks the written ones
+	for i := range page.Report {
+		inc := &page.Report[i]
+		if inc.Number == "" {
+			return nil, fmt.Errorf("incident %d of the report has no number", i+1)
+		}
+		if err := inc.Validate(); err != nil {
+			return nil, fmt.Errorf("incident %s: %v", inc.Number, err)
+		}
+	}
+	return &page, nil
+}
+
+/*
+GetIncidents requests link and follows the next links of the server, so the
+report holds the incidents of every page. The links are resolved against the
+url of the page they are in, a link seen before ends the walk
+*/
+func GetIncidents(link string) (*model.Incidents, error) {
+	var incidents *model.Incidents
+	seen := make(map[string]bool)
+	for link != "" && !seen[link] {
+		seen[link] = true
+
+		res, err := GetResponse(link)
+		if err != nil {
+			return nil, err
+		}
+		// validate response based on headers
+		if err := ValidateResponse(res); err != nil {
+			return nil, err
+		}
+		page, err := parsePage(res)
+		if err != nil {
+			return nil, err
+		}
+		if incidents == nil {
+			incidents = &page.Incidents
+		} else {
+			incidents.Report = append(incidents.Report, page.Report...)
+		}
+
+		if page.Next == "" {
+			break
+		}
+		base, err := url.Parse(link)
+		if err != nil {
+			return nil, err
+		}
+		next, err := url.Parse(page.Next)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next link %q: %v", page.Next, err)
+		}
+		link = base.ResolveReference(next).String()
+	}
+	return incidents, nil
 }
 
 func main() {
@@ -220,25 +422,38 @@ func main() {
 	Formatter.FullTimestamp = true
 	log.SetFormatter(Formatter)
 
-	// get url as first arg
-	url := os.Args[1]
-
-	// get the response using http client
-	res, err := GetResponse(url)
+	// url and token out of the flags, env and config file
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	// validate response based on headers
-	respErr := ValidateResponse(res)
-	if respErr != nil {
-		log.Fatal(respErr)
+	authToken = cfg.Token
+	tlsConfig, err = cfg.clientTLSConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
+	link := cfg.URL
 
-	// Read the body
-	incidents, err := ParseBody(res)
+	// the cache survives the run, so the next run can make conditional requests
+	if cfg.CacheFile != "" {
+		if err := loadCache(cfg.CacheFile); err != nil {
+			log.Warn("Ignoring the response cache: ", err)
+		}
+	}
+
+	// get every page of the incidents using http client
+	incidents, err := GetIncidents(link)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if cfg.CacheFile != "" {
+		if err := saveCache(cfg.CacheFile); err != nil {
+			log.Warn("Saving the response cache failed: ", err)
+		}
+	}
 
 	// print the tableFormat of the incidents report
 	tableFmt, err := tableFormat.Format((*incidents).Report)
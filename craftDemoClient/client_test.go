@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"model"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,7 +18,7 @@ func TestWalkIncs(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	obj := []Incident{{"a", "b", "c", "d", "High", "f"}}
+	obj := []model.Incident{{Number: "a", AssignedTo: "b", Description: "c", State: "Open", Priority: "High", Severity: "Low"}}
 
 	out, err := walkIncs(ctx, obj)
 	if err != nil {
@@ -90,6 +94,39 @@ func TestValidateResponse(t *testing.T) {
 	if err == nil {
 		t.Errorf("Expected error, got no error")
 	}
+
+	// content length matching the body
+	resp = w.Result()
+	resp.Header["Content-Type"][0] = "application/json"
+	resp.Header["Content-Length"] = []string{"38"}
+	err = ValidateResponse(resp)
+	if err != nil {
+		t.Errorf("Expected nil, got %v\n", err)
+	}
+}
+
+func TestValidateResponseAPIError(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"code":"invalid_transition","message":"INC1 cannot go from Closed to In Progress",`+
+			`"request_id":"req-42","details":{"from":"Closed","to":"In Progress","allowed":["Open"]}}`)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PATCH", "http://example.com/api/v1/incidents/INC1", nil))
+
+	err := ValidateResponse(w.Result())
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected *APIError, got %v", err)
+	}
+	if apiErr.Status != http.StatusConflict || apiErr.Code != "invalid_transition" || apiErr.Details["from"] != "Closed" {
+		t.Errorf("Expected decoded error, got %+v", apiErr)
+	}
+	expected := "server returned 409 invalid_transition: INC1 cannot go from Closed to In Progress (request id req-42)"
+	if err.Error() != expected {
+		t.Errorf("Expected %s, got %s", expected, err.Error())
+	}
 }
 
 func TestParseBody(t *testing.T) {
@@ -113,7 +150,7 @@ func TestParseBody(t *testing.T) {
 
 	// success case
 	handler = func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234"}]}`)
+		io.WriteString(w, `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}]}`)
 	}
 
 	//req := httptest.NewRequest("GET", "http://example.com/foo", nil)
@@ -129,6 +166,35 @@ func TestParseBody(t *testing.T) {
 		t.Errorf("Expected data, got nil")
 	}
 
+	// failure case - values unknown to the model are rejected
+	handler = func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234","priority":"Urgent"}]}`)
+	}
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	incidents, err = ParseBody(w.Result())
+	if err == nil || incidents != nil {
+		t.Errorf("Expected invalid priority error, got %v %v", incidents, err)
+	}
+
+	// failure case - incidents failing the validation of the model
+	for _, inc := range []string{
+		`{"number":"1234","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}`,
+		`{"number":"INC1234","description":"","state":"Open","priority":"High","severity":"Low"}`,
+		`{"description":"VM is hung","state":"Open","priority":"High","severity":"Low"}`,
+	} {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"Name":"ServiceNowQuery","Report":[`+inc+`]}`)
+		}
+		w = httptest.NewRecorder()
+		handler(w, req)
+
+		incidents, err = ParseBody(w.Result())
+		if err == nil || incidents != nil {
+			t.Errorf("%s: Expected invalid incident error, got %v %v", inc, incidents, err)
+		}
+	}
 }
 
 func TestMergeIncs(t *testing.T) {
@@ -172,8 +238,8 @@ func TestMergeIncs(t *testing.T) {
 }
 
 func TestGenerateAggReportPriority(t *testing.T) {
-	obj := []Incident{{"a", "b", "c", "d", "High", "f"},
-		{"b", "b", "c", "d", "High", "f"}}
+	obj := []model.Incident{{Number: "a", AssignedTo: "b", Description: "c", State: "Open", Priority: "High", Severity: "Low"},
+		{Number: "b", AssignedTo: "b", Description: "c", State: "Open", Priority: "High", Severity: "Low"}}
 	sum, err := GenerateAggReportPriority(obj)
 	if err != nil {
 		t.Errorf("Expected nil, got %v\n", err)
@@ -191,3 +257,144 @@ func TestGenerateAggReportPriority(t *testing.T) {
 	}
 
 }
+
+func TestGetResponseCache(t *testing.T) {
+	body := `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}]}`
+	hits, notModified := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	// first request fills the cache, second one is answered with 304
+	for i := 0; i < 2; i++ {
+		res, err := GetResponse(ts.URL)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v\n", err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected 200, got %v\n", res.StatusCode)
+		}
+		incidents, err := ParseBody(res)
+		if err != nil || len(incidents.Report) != 1 {
+			t.Errorf("Expected cached body, got %v\n", err)
+		}
+	}
+	if hits != 2 || notModified != 1 {
+		t.Errorf("Expected 2 requests and 1 not modified, got %v %v\n", hits, notModified)
+	}
+}
+
+func TestGetResponseToken(t *testing.T) {
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	authToken = "cdk_1_2"
+	defer func() { authToken = "" }()
+	if _, err := GetResponse(ts.URL + "/token"); err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	if authorization != "Bearer cdk_1_2" {
+		t.Errorf("Expected bearer token, got %q\n", authorization)
+	}
+}
+
+func TestResponseCacheFile(t *testing.T) {
+	notModified := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}]}`)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cache.json")
+
+	// a missing file is an empty cache
+	if err := loadCache(file); err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	if _, err := GetResponse(ts.URL + "/persisted"); err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	if err := saveCache(file); err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+
+	// a later run starts with the saved cache and gets a 304 answered from it
+	responseCache = make(map[string]*cachedResponse)
+	if err := loadCache(file); err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	res, err := GetResponse(ts.URL + "/persisted")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	if notModified != 1 {
+		t.Errorf("Expected a conditional request, got %v not modified\n", notModified)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected cached 200 response, got %v %v\n", res.StatusCode, res.Header)
+	}
+	incidents, err := ParseBody(res)
+	if err != nil || len(incidents.Report) != 1 {
+		t.Errorf("Expected cached body, got %v\n", err)
+	}
+
+	ioutil.WriteFile(file, []byte("not json"), 0600)
+	if err := loadCache(file); err == nil {
+		t.Errorf("Expected error for corrupt cache, got nil")
+	}
+}
+
+func TestGetIncidentsPages(t *testing.T) {
+	pages := map[string]string{
+		"":  `{"Name":"ServiceNowQuery","Report":[{"number":"INC1","description":"VM is hung","state":"Open","priority":"High","severity":"Low"},{"number":"INC2","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}],"total":3,"next":"/api/v1/list/incidents?limit=2&offset=2"}`,
+		"2": `{"Name":"ServiceNowQuery","Report":[{"number":"INC3","description":"VM is hung","state":"Open","priority":"High","severity":"Low"}],"total":3,"prev":"/api/v1/list/incidents?limit=2&offset=0"}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Query().Get("offset")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	incidents, err := GetIncidents(ts.URL + "/api/v1/list/incidents")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v\n", err)
+	}
+	if len(incidents.Report) != 3 || incidents.Report[2].Number != "INC3" {
+		t.Errorf("Expected the incidents of both pages, got %v\n", incidents.Report)
+	}
+
+	// a failing page fails the whole report
+	pages[""] = `{"Name":"ServiceNowQuery","Report":[],"next":"/api/v1/list/incidents?offset=9"}`
+	if _, err := GetIncidents(ts.URL + "/api/v1/list/incidents?page=failing"); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
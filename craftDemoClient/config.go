@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefix of the environment variables, e.g. CRAFTDEMO_TOKEN
+const EnvPrefix = "CRAFTDEMO_"
+
+// config file read when -config is not given, it is fine if it does not exist
+const defaultConfigFile = ".craftdemo.yaml"
+
+// responses are cached in this file in the home directory when -cache-file is not given
+const defaultCacheFile = ".craftdemo-cache.json"
+
+// clientConfig holds the settings of the client
+type clientConfig struct {
+	Token string `yaml:"token"` // api key or bearer token of the server
+	Cert  string `yaml:"cert"`  // client certificate and key presented to servers using mutual TLS
+	Key   string `yaml:"key"`
+
+	// trust of the server certificate, the system roots are used by default
+	CACert     string `yaml:"ca_cert"`     // CA bundle verifying the server instead of the system roots
+	ServerName string `yaml:"server_name"` // name expected in the server certificate, default the url host
+	Pin        string `yaml:"pin"`         // comma separated sha256//<base64> hashes of trusted public keys
+	Insecure   bool   `yaml:"insecure"`    // skip verification entirely, for debugging only
+
+	CacheFile string `yaml:"cache_file"` // responses kept for conditional requests of the next run
+
+	URL string `yaml:"-"`
+}
+
+/*
+loadConfig reads the config file, the environment and the command line
+craftDemoClient [-token TOKEN] [-config FILE] URL
+Later ones take precedence: config file < environment < flags
+*/
+func loadConfig(args []string, getenv func(string) string) (*clientConfig, error) {
+	fs := flag.NewFlagSet("craftDemoClient", flag.ContinueOnError)
+	configFile := fs.String("config", "", "yaml config file, default ~/"+defaultConfigFile+", also "+EnvPrefix+"CONFIG")
+	token := fs.String("token", "", "api key or bearer token, also "+EnvPrefix+"TOKEN")
+	cert := fs.String("cert", "", "client certificate for mutual TLS, also "+EnvPrefix+"CERT")
+	key := fs.String("key", "", "key of the client certificate, also "+EnvPrefix+"KEY")
+	caCert := fs.String("ca-cert", "", "CA bundle verifying the server, also "+EnvPrefix+"CA_CERT")
+	serverName := fs.String("server-name", "", "name expected in the server certificate, also "+EnvPrefix+"SERVER_NAME")
+	pin := fs.String("pin", "", "comma separated sha256//<base64> public key pins of the server, also "+EnvPrefix+"PIN")
+	insecure := fs.Bool("insecure", false, "do not verify the server certificate, also "+EnvPrefix+"INSECURE")
+	cacheFile := fs.String("cache-file", "", "responses cached for conditional requests, default ~/"+defaultCacheFile+", also "+EnvPrefix+"CACHE_FILE")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("usage: craftDemoClient [flags] URL")
+	}
+
+	var cfg clientConfig
+	if *configFile == "" {
+		*configFile = getenv(EnvPrefix + "CONFIG")
+	}
+	optional := false
+	if *configFile == "" {
+		if home := getenv("HOME"); home != "" {
+			*configFile = filepath.Join(home, defaultConfigFile)
+			optional = true
+		}
+	}
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil && !(optional && os.IsNotExist(err)) {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %v", *configFile, err)
+		}
+	}
+
+	settings := []struct {
+		env   string
+		flag  string
+		value *string
+	}{
+		{"TOKEN", *token, &cfg.Token},
+		{"CERT", *cert, &cfg.Cert},
+		{"KEY", *key, &cfg.Key},
+		{"CA_CERT", *caCert, &cfg.CACert},
+		{"SERVER_NAME", *serverName, &cfg.ServerName},
+		{"PIN", *pin, &cfg.Pin},
+		{"CACHE_FILE", *cacheFile, &cfg.CacheFile},
+	}
+	for _, s := range settings {
+		if v := getenv(EnvPrefix + s.env); v != "" {
+			*s.value = v
+		}
+		if s.flag != "" {
+			*s.value = s.flag
+		}
+	}
+	if v := getenv(EnvPrefix + "INSECURE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%sINSECURE: %v", EnvPrefix, err)
+		}
+		cfg.Insecure = b
+	}
+	if *insecure {
+		cfg.Insecure = true
+	}
+
+	if cfg.CacheFile == "" {
+		if home := getenv("HOME"); home != "" {
+			cfg.CacheFile = filepath.Join(home, defaultCacheFile)
+		}
+	}
+
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, fmt.Errorf("client certificate and key must be given together")
+	}
+	if cfg.Insecure && (cfg.CACert != "" || cfg.Pin != "") {
+		return nil, fmt.Errorf("insecure can not be combined with a CA certificate or pins")
+	}
+	cfg.URL = fs.Arg(0)
+	return &cfg, nil
+}
+
+/*
+clientTLSConfig returns the tls config of the requests
+The server certificate is verified against the CA bundle or the system roots.
+With pins and no CA bundle the pin of the leaf replaces the chain verification,
+which allows to trust a self signed server; with both, both have to pass.
+The client certificate is presented if configured
+*/
+func (c *clientConfig) clientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: c.ServerName}
+	if c.Insecure {
+		log.Warn("Server certificate is not verified, the connection can be intercepted")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+	}
+	if c.Pin != "" {
+		pins, err := parsePins(c.Pin)
+		if err != nil {
+			return nil, err
+		}
+		// the pins are checked by verifyPins instead of the chain
+		tlsConfig.InsecureSkipVerify = c.CACert == ""
+		tlsConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// prefix of the public key pins, as used by curl --pinnedpubkey
+const pinPrefix = "sha256//"
+
+// parsePins decodes the comma separated pins into sha256 hashes
+func parsePins(pins string) ([][]byte, error) {
+	var hashes [][]byte
+	for _, pin := range strings.Split(pins, ",") {
+		pin = strings.TrimSpace(pin)
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+		if err != nil || !strings.HasPrefix(pin, pinPrefix) || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q, expected %s<base64 sha256>", pin, pinPrefix)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// spkiPin returns the pin of the public key of cert
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+/*
+verifyPins accepts the server if its certificate carries one of the pinned public keys
+Without chain verification only the leaf counts, the handshake proves nothing about
+the other certificates sent. With a verified chain any certificate of it may be pinned
+*/
+func verifyPins(pins [][]byte) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var candidates []*x509.Certificate
+		for _, chain := range verifiedChains {
+			candidates = append(candidates, chain...)
+		}
+		if len(verifiedChains) == 0 && len(rawCerts) > 0 {
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			candidates = []*x509.Certificate{leaf}
+		}
+		for _, cert := range candidates {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(hash[:], pin) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("server public key does not match any pin")
+	}
+}
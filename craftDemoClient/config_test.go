@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	home, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	env := map[string]string{"HOME": home}
+	getenv := func(name string) string { return env[name] }
+
+	// no config file in home is fine
+	cfg, err := loadConfig([]string{"https://localhost/api"}, getenv)
+	if err != nil || cfg.Token != "" || cfg.URL != "https://localhost/api" {
+		t.Errorf("Expected url without token, got %+v %v", cfg, err)
+	}
+	if cfg.CacheFile != filepath.Join(home, defaultCacheFile) {
+		t.Errorf("Expected cache file in home, got %q", cfg.CacheFile)
+	}
+
+	ioutil.WriteFile(filepath.Join(home, defaultConfigFile), []byte("token: from-file\n"), 0600)
+	cfg, _ = loadConfig([]string{"https://localhost/api"}, getenv)
+	if cfg.Token != "from-file" {
+		t.Errorf("Expected token of the config file, got %q", cfg.Token)
+	}
+
+	// file < env < flag
+	env["CRAFTDEMO_TOKEN"] = "from-env"
+	cfg, _ = loadConfig([]string{"https://localhost/api"}, getenv)
+	if cfg.Token != "from-env" {
+		t.Errorf("Expected token of the environment, got %q", cfg.Token)
+	}
+	cfg, _ = loadConfig([]string{"-token", "from-flag", "https://localhost/api"}, getenv)
+	if cfg.Token != "from-flag" {
+		t.Errorf("Expected token of the flag, got %q", cfg.Token)
+	}
+
+	failures := [][]string{
+		{},
+		{"-config", filepath.Join(home, "missing.yaml"), "https://localhost/api"},
+		{"-unknown", "https://localhost/api"},
+		{"https://localhost/a", "https://localhost/b"},
+	}
+	for _, args := range failures {
+		if _, err := loadConfig(args, getenv); err == nil {
+			t.Errorf("%v: Expected error, got nil", args)
+		}
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// self signed client certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	var presented string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	noEnv := func(string) string { return "" }
+	cfg, err := loadConfig([]string{"-cert", certFile, "-key", keyFile, "-pin", spkiPin(ts.Certificate()), ts.URL}, noEnv)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	tlsConfig, err = cfg.clientTLSConfig()
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer func() { tlsConfig = &tls.Config{} }()
+
+	if _, err := GetResponse(ts.URL + "/cert"); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if presented != "alice" {
+		t.Errorf("Expected the client certificate of alice, got %q", presented)
+	}
+
+	// certificate and key go together
+	if _, err := loadConfig([]string{"-cert", certFile, ts.URL}, noEnv); err == nil {
+		t.Errorf("Expected error for certificate without key, got nil")
+	}
+	cfg.Key = certFile
+	if _, err := cfg.clientTLSConfig(); err == nil {
+		t.Errorf("Expected error for mismatching key, got nil")
+	}
+}
+
+func TestServerVerification(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	defer func() { tlsConfig = &tls.Config{} }()
+
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644)
+
+	pin := spkiPin(ts.Certificate())
+	otherPin := "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		args []string
+		ok   bool
+	}{
+		// system roots do not know the test server
+		{[]string{}, false},
+		{[]string{"-insecure"}, true},
+		{[]string{"-ca-cert", caFile}, true},
+		{[]string{"-ca-cert", caFile, "-server-name", "example.com"}, true},
+		{[]string{"-ca-cert", caFile, "-server-name", "other.example"}, false},
+		{[]string{"-pin", pin}, true},
+		{[]string{"-pin", otherPin + "," + pin}, true},
+		{[]string{"-pin", otherPin}, false},
+		{[]string{"-ca-cert", caFile, "-pin", pin}, true},
+		{[]string{"-ca-cert", caFile, "-pin", otherPin}, false},
+	}
+	noEnv := func(string) string { return "" }
+	for _, test := range tests {
+		cfg, err := loadConfig(append(test.args, ts.URL), noEnv)
+		if err != nil {
+			t.Fatalf("%v: Expected nil, got %v", test.args, err)
+		}
+		tlsConfig, err = cfg.clientTLSConfig()
+		if err != nil {
+			t.Fatalf("%v: Expected nil, got %v", test.args, err)
+		}
+		_, err = GetResponse(ts.URL + "/verify")
+		if test.ok && err != nil {
+			t.Errorf("%v: Expected nil, got %v", test.args, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%v: Expected verification error, got nil", test.args)
+		}
+	}
+
+	// a server sending the pinned certificate after its own leaf does not hold the pinned key
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mallory"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	mitm := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mitm.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{der, ts.Certificate().Raw},
+		PrivateKey:  key,
+	}}}
+	mitm.StartTLS()
+	defer mitm.Close()
+	for _, args := range [][]string{{"-pin", pin}, {"-ca-cert", caFile, "-pin", pin}} {
+		cfg, _ := loadConfig(append(args, mitm.URL), noEnv)
+		tlsConfig, _ = cfg.clientTLSConfig()
+		if _, err := GetResponse(mitm.URL + "/verify"); err == nil {
+			t.Errorf("%v: Expected pin mismatch for a foreign leaf, got nil", args)
+		}
+	}
+
+	// invalid settings
+	failures := [][]string{
+		{"-insecure", "-pin", pin},
+		{"-insecure", "-ca-cert", caFile},
+	}
+	for _, args := range failures {
+		if _, err := loadConfig(append(args, ts.URL), noEnv); err == nil {
+			t.Errorf("%v: Expected error, got nil", args)
+		}
+	}
+	for _, cfg := range []clientConfig{{Pin: "abc"}, {Pin: "sha256//abc"}, {CACert: filepath.Join(dir, "missing.crt")}} {
+		if _, err := cfg.clientTLSConfig(); err == nil {
+			t.Errorf("%+v: Expected error, got nil", cfg)
+		}
+	}
+}
@@ -136,15 +136,20 @@ func ExtractContents(in interface{}, m map[string]int, header *[]string, content
 					*contents = make([][]string, val.Len())
 				}
 				for i := 0; i < v.NumField(); i++ {
+					// fields tagged `table:"-"` are left out of the table
+					if typeOfS.Field(i).Tag.Get("table") == "-" {
+						continue
+					}
 					// get the value as string
 					//fmt.Printf("Type is %v\n",v.Field(i).Type())
 					var a string
 					switch fieldType := v.Field(i).Type().Kind().String(); fieldType {
 					// TODO: Other basic data types can be implemented later
+					// String and Int also cover named types, e.g. model.Priority
 					case "string":
-						a = v.Field(i).Interface().(string)
+						a = v.Field(i).String()
 					case "int":
-						a = strconv.Itoa(v.Field(i).Interface().(int))
+						a = strconv.Itoa(int(v.Field(i).Int()))
 					default:
 						return errors.New("unsupported format")
 					}
@@ -41,6 +41,22 @@ func TestExtractContents(t *testing.T) {
 		t.Errorf("Expected string test, but got %v", contents[0][0])
 	}
 
+	// success case - named types and skipped fields
+	type Level string
+	type TaggedStruct struct {
+		Name    string
+		Level   Level
+		History []string `table:"-"`
+	}
+	header, contents = nil, nil
+	err = ExtractContents([]TaggedStruct{{"test", "High", []string{"a"}}}, m, &header, &contents)
+	if err != nil {
+		t.Errorf("Expected nil, got %v\n", err)
+	}
+	if len(header) != 2 || header[1] != "Level" || contents[0][1] != "High" {
+		t.Errorf("Expected Name and Level columns, got %v %v", header, contents)
+	}
+
 	// failure case 1 - unsupported data type
 	type TestFailStruct struct {
 		Val float64
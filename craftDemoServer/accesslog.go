@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// formats of the access log
+const (
+	AccessLogStructured = "structured" // logrus entry with the request as fields
+	AccessLogCombined   = "combined"   // apache combined log format
+)
+
+// header carrying the request id, it is passed through from the client or generated
+const RequestIDHeader = "X-Request-ID"
+
+// request ids of clients are only used if they are safe to log
+var requestIDFormat = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	userKey
+)
+
+// requestInfo is shared by RequestLogger and the handlers, which fill in the user
+type requestInfo struct {
+	id   string
+	user string
+}
+
+func requestInfoOf(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// requestID returns the id RequestLogger assigned to r, "" outside of RequestLogger
+func requestID(r *http.Request) string {
+	if info := requestInfoOf(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// newRequestID returns a random 128 bit id in hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code and the size of the body written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+/*
+RequestLogger logs every request along with its status code and size and
+records its latency in the metrics, by route of targetMux.
+Every request gets an id, taken from the X-Request-ID header or generated,
+which is sent back in the response and logged.
+format is AccessLogStructured or AccessLogCombined, combined lines are written to out
+*/
+func RequestLogger(targetMux http.Handler, format string, out io.Writer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDFormat.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		info := &requestInfo{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		targetMux.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		duration := time.Since(start)
+		requestMetrics.observe(route(targetMux, r), r.Method, rec.status, duration)
+
+		if format == AccessLogCombined {
+			name := info.user
+			if name == "" {
+				name = peerIdentity(r)
+			}
+			fmt.Fprintln(out, combinedLogLine(r, name, rec.status, rec.bytes, start))
+			return
+		}
+		log.WithFields(log.Fields{
+			"request_id":  id,
+			"user":        info.user,
+			"client_cert": peerIdentity(r),
+			"method":      r.Method,
+			"uri":         r.RequestURI,
+			"remote_addr": r.RemoteAddr,
+			"status":      rec.status,
+			"bytes":       rec.bytes,
+			"duration_ms": float64(duration) / float64(time.Millisecond),
+			"user_agent":  r.UserAgent(),
+		}).Info("request")
+	})
+}
+
+/*
+combinedLogLine formats the request in the apache combined log format
+host ident user [time] "request line" status bytes "referer" "user agent"
+*/
+func combinedLogLine(r *http.Request, name string, status int, bytes int, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q",
+		host,
+		orDash(name),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		status,
+		size,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()),
+	)
+}
+
+// orDash returns "-" for empty values, as apache does
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+	}), AccessLogCombined, &bytes.Buffer{})
+
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"bad id\nwith newline", false},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set(RequestIDHeader, test.header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		id := rr.Header().Get(RequestIDHeader)
+		if id != seen {
+			t.Errorf("Expected the response id %q to be passed to the handler, got %q", id, seen)
+		}
+		if test.keep && id != test.header {
+			t.Errorf("Expected %q, got %q", test.header, id)
+		}
+		if !test.keep && !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+			t.Errorf("%q: Expected generated id, got %q", test.header, id)
+		}
+	}
+}
+
+func TestRequestLoggerStructured(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFormatter(new(log.TextFormatter))
+
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), AccessLogStructured, nil)
+
+	req := httptest.NewRequest("POST", "/tea?cup=1", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected json log entry, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"msg":         "request",
+		"request_id":  "req-1",
+		"method":      "POST",
+		"uri":         "/tea?cup=1",
+		"remote_addr": "192.0.2.1:1234",
+		"status":      float64(http.StatusTeapot),
+		"bytes":       float64(len("short and stout")),
+	}
+	for field, value := range expected {
+		if entry[field] != value {
+			t.Errorf("Expected %s %v, got %v", field, value, entry[field])
+		}
+	}
+	if _, ok := entry["duration_ms"].(float64); !ok {
+		t.Errorf("Expected duration_ms, got %v", entry["duration_ms"])
+	}
+}
+
+func TestRequestLoggerCombined(t *testing.T) {
+	var buf bytes.Buffer
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}), AccessLogCombined, &buf)
+
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents?state=Open", nil)
+	req.Header.Set("User-Agent", "curl/7.64")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	combined := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+		`"GET /api/v1/list/incidents\?state=Open HTTP/1\.1" 200 5 "-" "curl/7\.64"\n$`)
+	if !combined.MatchString(buf.String()) {
+		t.Errorf("Expected combined log line, got %q", buf.String())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// auditLog records who was allowed or denied to do what, one json object per line
+var auditLog = newAuditLogger(os.Stderr)
+
+func newAuditLogger(out io.Writer) *log.Logger {
+	logger := log.New()
+	logger.Out = out
+	logger.Formatter = &log.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	return logger
+}
+
+// audit writes the decision on the request to the audit log
+func audit(r *http.Request, action string, number string, allowed bool, reason string) {
+	outcome := "allowed"
+	if !allowed {
+		outcome = "denied"
+	}
+	auditLog.WithFields(log.Fields{
+		"request_id":  requestID(r),
+		"identity":    identity(r),
+		"remote_addr": r.RemoteAddr,
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"action":      action,
+		"incident":    number,
+		"outcome":     outcome,
+		"reason":      reason,
+	}).Info("audit")
+}
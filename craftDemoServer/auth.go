@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authenticator validates the credentials of a request
+type Authenticator interface {
+	Authenticate(credential string, now time.Time) (string, error)
+}
+
+/*
+requireAuth only passes requests carrying a valid api key or token
+in the Authorization header, e.g. Authorization: Bearer cdk_...
+With mutual TLS a verified client certificate is accepted instead of a token.
+The holder of the credential is made available by user(r)
+*/
+func requireAuth(auth Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		credential := ""
+		if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			credential = strings.TrimSpace(header[7:])
+		}
+		if credential == "" {
+			if name := peerIdentity(r); name != "" {
+				authenticated(w, r, name, next)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="craftdemo"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing bearer token")
+			return
+		}
+		name, err := auth.Authenticate(credential, time.Now())
+		if err != nil {
+			audit(r, action(r), "", false, "authentication failed: "+err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="craftdemo", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", ErrInvalidCredentials.Error())
+			return
+		}
+		authenticated(w, r, name, next)
+	}
+}
+
+// authenticated passes the request on to next as made by name
+func authenticated(w http.ResponseWriter, r *http.Request, name string, next http.HandlerFunc) {
+	if info := requestInfoOf(r); info != nil {
+		info.user = name
+	}
+	next(w, r.WithContext(context.WithValue(r.Context(), userKey, name)))
+}
+
+// user returns the authenticated holder of the request credentials, "" without auth
+func user(r *http.Request) string {
+	name, _ := r.Context().Value(userKey).(string)
+	return name
+}
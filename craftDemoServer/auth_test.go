@@ -0,0 +1,79 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeAuth maps credentials to their holders
+type fakeAuth map[string]string
+
+func (f fakeAuth) Authenticate(credential string, now time.Time) (string, error) {
+	if name, ok := f[credential]; ok {
+		return name, nil
+	}
+	return "", ErrInvalidCredentials
+}
+
+func TestRequireAuth(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{Name: "Fake"}}
+	server := NewIncidentServer(store)
+	server.auth = fakeAuth{"secret": "alice"}
+	handler := server.Handler()
+
+	tests := []struct {
+		path          string
+		authorization string
+		expected      int
+	}{
+		{"/api/v1/list/incidents", "", http.StatusUnauthorized},
+		{"/api/v1/list/incidents", "Bearer wrong", http.StatusUnauthorized},
+		{"/api/v1/list/incidents", "Basic secret", http.StatusUnauthorized},
+		{"/api/v1/list/incidents", "Bearer secret", http.StatusOK},
+		{"/api/v1/list/incidents", "bearer secret", http.StatusOK},
+		{"/api/v1/incidents/summary", "", http.StatusUnauthorized},
+		{"/api/v1/incidents/INC1", "", http.StatusUnauthorized},
+		// probes and metrics stay open
+		{"/healthz", "", http.StatusOK},
+		{"/metrics", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("%s %q: handler returned wrong status code: got %v want %v",
+				test.path, test.authorization, status, test.expected)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %q: Expected WWW-Authenticate header", test.path, test.authorization)
+		}
+	}
+}
+
+func TestRequireAuthClientCert(t *testing.T) {
+	server := NewIncidentServer(&fakeStore{})
+	server.auth = fakeAuth{}
+	handler := server.Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "alice"}},
+	}}}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
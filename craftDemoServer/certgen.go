@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// names the server certificate is valid for when none are given
+const defaultTLSHosts = "localhost,127.0.0.1,::1"
+
+// certFiles are the files written by generateCerts
+type certFiles struct {
+	CACert string
+	CAKey  string
+	Cert   string
+	Key    string
+}
+
+// certFilesIn returns the default file names in dir
+func certFilesIn(dir string) certFiles {
+	return certFiles{
+		CACert: filepath.Join(dir, "ca.crt"),
+		CAKey:  filepath.Join(dir, "ca.key"),
+		Cert:   filepath.Join(dir, "server.crt"),
+		Key:    filepath.Join(dir, "server.key"),
+	}
+}
+
+/*
+generateCerts creates an ECDSA P-256 CA and a server certificate signed by it
+hosts are the DNS names and IP addresses of the server.
+Clients trust the server with the CA certificate (-ca-cert) or the returned public key pin (-pin).
+Existing files are not overwritten
+*/
+func generateCerts(files certFiles, hosts []string, validity time.Duration, now time.Time) (string, error) {
+	for _, file := range []string{files.CACert, files.CAKey, files.Cert, files.Key} {
+		if _, err := os.Stat(file); err == nil {
+			return "", fmt.Errorf("%s already exists", file)
+		}
+	}
+	if len(hosts) == 0 {
+		return "", fmt.Errorf("the server certificate needs at least one host")
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "craftDemo CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDer, err := createCert(ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	der, err := createCert(server, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writePEM(files.CACert, "CERTIFICATE", caDer, 0644); err != nil {
+		return "", err
+	}
+	if err := writeKey(files.CAKey, caKey); err != nil {
+		return "", err
+	}
+	if err := writePEM(files.Cert, "CERTIFICATE", der, 0644); err != nil {
+		return "", err
+	}
+	if err := writeKey(files.Key, key); err != nil {
+		return "", err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(spki)
+	return "sha256//" + base64.StdEncoding.EncodeToString(hash[:]), nil
+}
+
+// createCert signs template with a random serial number
+func createCert(template *x509.Certificate, parent *x509.Certificate, pub *ecdsa.PublicKey, signer *ecdsa.PrivateKey) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+}
+
+func writeKey(file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(file, "EC PRIVATE KEY", der, 0600)
+}
+
+func writePEM(file string, blockType string, der []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), mode)
+}
+
+/*
+genCertCommand writes a CA and a server certificate into a directory, it returns the exit code
+craftDemoServer gen-cert -dir certs -hosts localhost,incidents.example.com -validity 8760h
+*/
+func genCertCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen-cert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", ".", "directory of ca.crt, ca.key, server.crt and server.key")
+	hosts := fs.String("hosts", defaultTLSHosts, "comma separated DNS names and IP addresses of the server")
+	validity := fs.Duration("validity", 365*24*time.Hour, "validity of the certificates")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *validity <= 0 {
+		fmt.Fprintln(stderr, "validity must be positive")
+		return 2
+	}
+
+	files := certFilesIn(*dir)
+	pin, err := generateCerts(files, splitList(*hosts), *validity, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "wrote %s, %s, %s and %s\n", files.CACert, files.CAKey, files.Cert, files.Key)
+	fmt.Fprintf(stdout, "clients trust the server with -ca-cert %s or -pin %s\n", files.CACert, pin)
+	return 0
+}
+
+/*
+ensureCerts generates the server certificate and key configured in cfg if neither exists
+and returns the pin of the new key, "" if the files were already there.
+The CA is written next to the certificate
+*/
+func ensureCerts(cfg *Config, now time.Time) (string, error) {
+	_, certErr := os.Stat(cfg.TLSCert)
+	_, keyErr := os.Stat(cfg.TLSKey)
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		return "", nil
+	}
+	files := certFilesIn(filepath.Dir(cfg.TLSCert))
+	files.Cert, files.Key = cfg.TLSCert, cfg.TLSKey
+	return generateCerts(files, splitList(cfg.TLSHosts), 365*24*time.Hour, now)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := certFilesIn(filepath.Join(dir, "tls"))
+	pin, err := generateCerts(files, []string{"localhost", "127.0.0.1", "incidents.example.com"}, time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if !strings.HasPrefix(pin, "sha256//") {
+		t.Errorf("Expected pin, got %q", pin)
+	}
+	for _, key := range []string{files.CAKey, files.Key} {
+		if info, _ := os.Stat(key); info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s readable by the owner only, got %v", key, info.Mode())
+		}
+	}
+
+	// the server certificate is signed by the CA and valid for every host
+	pair, err := tls.LoadX509KeyPair(files.Cert, files.Key)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	caPEM, _ := ioutil.ReadFile(files.CACert)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	cert, _ := x509.ParseCertificate(pair.Certificate[0])
+	for _, host := range []string{"localhost", "127.0.0.1", "incidents.example.com"} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("%s: Expected nil, got %v", host, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "other.example.com", Roots: roots}); err == nil {
+		t.Errorf("Expected error for other host, got nil")
+	}
+
+	// a TLS server comes up with the generated files
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	ts.StartTLS()
+	defer ts.Close()
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	res, err := client.Get(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	// existing files are kept
+	if _, err := generateCerts(files, []string{"localhost"}, time.Hour, time.Now()); err == nil {
+		t.Errorf("Expected error for existing files, got nil")
+	}
+}
+
+func TestEnsureCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := defaultConfig()
+	cfg.TLSCert, cfg.TLSKey = filepath.Join(dir, "my.crt"), filepath.Join(dir, "my.key")
+	pin, err := ensureCerts(cfg, time.Now())
+	if err != nil || pin == "" {
+		t.Fatalf("Expected generated certificate, got %q %v", pin, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ca.crt")); err != nil {
+		t.Errorf("Expected CA next to the certificate, got %v", err)
+	}
+	// second start keeps the certificate
+	if pin, err := ensureCerts(cfg, time.Now()); err != nil || pin != "" {
+		t.Errorf("Expected nothing generated, got %q %v", pin, err)
+	}
+}
+
+func TestGenCertCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var stdout, stderr bytes.Buffer
+	if code := genCertCommand([]string{"-dir", dir, "-hosts", "localhost"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "-pin sha256//") {
+		t.Errorf("Expected pin in output, got %s", stdout.String())
+	}
+
+	failures := [][]string{
+		{"-dir", dir},
+		{"-dir", filepath.Join(dir, "new"), "-hosts", ","},
+		{"-dir", filepath.Join(dir, "new"), "-validity", "0s"},
+		{"-unknown"},
+	}
+	for _, args := range failures {
+		if code := genCertCommand(args, &stdout, &stderr); code == 0 {
+			t.Errorf("%v: Expected failure, got 0", args)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix of the environment variables, e.g. CRAFTDEMO_ADDR
+const EnvPrefix = "CRAFTDEMO_"
+
+// Config of the incident server
+type Config struct {
+	Addr            string        `yaml:"addr"`
+	TLSCert         string        `yaml:"tls_cert"`
+	TLSKey          string        `yaml:"tls_key"`
+	TLSAutoGenerate bool          `yaml:"tls_auto_generate"`
+	TLSHosts        string        `yaml:"tls_hosts"`
+	ClientCA        string        `yaml:"client_ca"`
+	AuthKeysFile    string        `yaml:"auth_keys_file"`
+	PolicyFile      string        `yaml:"policy_file"`
+	AuditLog        string        `yaml:"audit_log"`
+	Store           string        `yaml:"store"`
+	DataFile        string        `yaml:"data_file"`
+	LogLevel        string        `yaml:"log_level"`
+	LogFormat       string        `yaml:"log_format"`
+	AccessLogFormat string        `yaml:"access_log_format"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	RateLimit       float64       `yaml:"rate_limit"`
+	RateBurst       int64         `yaml:"rate_burst"`
+	MaxBodySize     int64         `yaml:"max_body_size"`
+	CORSOrigins     string        `yaml:"cors_origins"`
+	ReloadInterval  time.Duration `yaml:"reload_interval"`
+}
+
+// defaultConfig is used for everything not set by the file, env or flags
+func defaultConfig() *Config {
+	return &Config{
+		Addr:            ":443",
+		TLSCert:         "server.crt",
+		TLSKey:          "server.key",
+		TLSHosts:        defaultTLSHosts,
+		Store:           "servicenow",
+		DataFile:        "incidents.json",
+		LogLevel:        "info",
+		LogFormat:       "json",
+		AccessLogFormat: AccessLogStructured,
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		RateLimit:       10,
+		RateBurst:       20,
+		MaxBodySize:     64 << 10,
+		ReloadInterval:  2 * time.Second,
+	}
+}
+
+// setting is a config value that can be given as flag or environment variable
+// the flag is -name, the environment variable CRAFTDEMO_NAME with '-' replaced by '_'
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func stringSetting(name string, usage string, field func(c *Config) *string) setting {
+	return setting{name, usage, func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func boolSetting(name string, usage string, field func(c *Config) *bool) setting {
+	return setting{name, usage, func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func intSetting(name string, usage string, field func(c *Config) *int64) setting {
+	return setting{name, usage, func(c *Config, v string) error {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}}
+}
+
+func floatSetting(name string, usage string, field func(c *Config) *float64) setting {
+	return setting{name, usage, func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}}
+}
+
+func durationSetting(name string, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{name, usage, func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("addr", "listen address", func(c *Config) *string { return &c.Addr }),
+	stringSetting("tls-cert", "TLS certificate file", func(c *Config) *string { return &c.TLSCert }),
+	stringSetting("tls-key", "TLS key file", func(c *Config) *string { return &c.TLSKey }),
+	boolSetting("tls-auto-generate", "generate a CA and the TLS certificate and key on start if they do not exist", func(c *Config) *bool { return &c.TLSAutoGenerate }),
+	stringSetting("tls-hosts", "comma separated names of the generated TLS certificate", func(c *Config) *string { return &c.TLSHosts }),
+	stringSetting("client-ca", "CA bundle verifying client certificates, enables mutual TLS", func(c *Config) *string { return &c.ClientCA }),
+	stringSetting("auth-keys-file", "api keys file, unset leaves the api open to everyone", func(c *Config) *string { return &c.AuthKeysFile }),
+	stringSetting("policy-file", "role policy of the api identities, empty allows everything", func(c *Config) *string { return &c.PolicyFile }),
+	stringSetting("audit-log", "file the audit trail is appended to, empty writes it to stderr", func(c *Config) *string { return &c.AuditLog }),
+	stringSetting("store", "incident store backend", func(c *Config) *string { return &c.Store }),
+	stringSetting("data-file", "data file of the incident store", func(c *Config) *string { return &c.DataFile }),
+	stringSetting("log-level", "log level (debug, info, warn, error)", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("log-format", "log format (json, text)", func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("access-log-format", "access log format (structured, combined)", func(c *Config) *string { return &c.AccessLogFormat }),
+	durationSetting("read-timeout", "maximum duration for reading a request", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationSetting("write-timeout", "maximum duration for writing a response", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationSetting("idle-timeout", "maximum time to wait for the next request on keep-alive connections", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	durationSetting("shutdown-timeout", "maximum time to drain in-flight requests on shutdown", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	floatSetting("rate-limit", "api requests per second of every client, 0 disables rate limiting", func(c *Config) *float64 { return &c.RateLimit }),
+	intSetting("rate-burst", "api requests a client can make at once", func(c *Config) *int64 { return &c.RateBurst }),
+	stringSetting("cors-origins", "comma separated origins browsers may call the api from, * allows all", func(c *Config) *string { return &c.CORSOrigins }),
+	intSetting("max-body-size", "maximum size of the request body of writes in bytes", func(c *Config) *int64 { return &c.MaxBodySize }),
+	durationSetting("reload-interval", "how often the store file is checked for changes", func(c *Config) *time.Duration { return &c.ReloadInterval }),
+}
+
+// envName returns the environment variable of a setting
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+/*
+loadConfig builds the config out of defaults, the optional yaml config file,
+environment variables and command line flags. Later ones take precedence:
+defaults < config file < environment < flags
+The config file is given by -config or CRAFTDEMO_CONFIG
+*/
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("craftDemoServer", flag.ContinueOnError)
+	configFile := fs.String("config", "", "yaml config file, also "+envName("config"))
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.name] = fs.String(s.name, "", s.usage+", also "+envName(s.name))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	cfg := defaultConfig()
+
+	if *configFile == "" {
+		*configFile = getenv(envName("config"))
+	}
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		// unknown keys are most likely typos, reject them
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %v", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(envName(s.name)); v != "" {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %v", envName(s.name), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if s := findSetting(f.Name); s != nil && err == nil {
+			if setErr := s.set(cfg, *values[f.Name]); setErr != nil {
+				err = fmt.Errorf("-%s: %v", f.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma separated list, dropping empty elements
+func splitList(hosts string) []string {
+	var result []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// findSetting returns the setting with the given name
+func findSetting(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// validate checks the values that can not be checked by their type
+func (c *Config) validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.TLSAutoGenerate && len(splitList(c.TLSHosts)) == 0 {
+		return fmt.Errorf("tls hosts must not be empty when generating the certificate")
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		return fmt.Errorf("log format must be json or text, got %q", c.LogFormat)
+	}
+	if c.AccessLogFormat != AccessLogStructured && c.AccessLogFormat != AccessLogCombined {
+		return fmt.Errorf("access log format must be %s or %s, got %q",
+			AccessLogStructured, AccessLogCombined, c.AccessLogFormat)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %v", c.RateLimit)
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		return fmt.Errorf("rate burst must be at least 1, got %d", c.RateBurst)
+	}
+	if c.MaxBodySize <= 0 {
+		return fmt.Errorf("max body size must be positive, got %d", c.MaxBodySize)
+	}
+	durations := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+		{"reload interval", c.ReloadInterval},
+	}
+	for _, duration := range durations {
+		if duration.d <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", duration.name, duration.d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	noEnv := func(string) string { return "" }
+
+	// defaults
+	cfg, err := loadConfig(nil, noEnv)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if *cfg != *defaultConfig() {
+		t.Errorf("Expected defaults, got %+v", cfg)
+	}
+	// auth is opt-in, a default start does not need a keys file
+	if cfg.AuthKeysFile != "" {
+		t.Errorf("Expected no keys file by default, got %q", cfg.AuthKeysFile)
+	}
+
+	file, err := ioutil.TempFile("", "config*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("addr: :8443\ndata_file: file.json\nlog_level: debug\nread_timeout: 5s\n")
+	file.Close()
+
+	env := map[string]string{
+		"CRAFTDEMO_CONFIG":    file.Name(),
+		"CRAFTDEMO_DATA_FILE": "env.json",
+		"CRAFTDEMO_LOG_LEVEL": "warn",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	// file < env < flags
+	cfg, err = loadConfig([]string{"-log-level", "error", "-idle-timeout", "1m"}, getenv)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if cfg.Addr != ":8443" || cfg.ReadTimeout != 5*time.Second {
+		t.Errorf("Expected values of the config file, got %+v", cfg)
+	}
+	if cfg.DataFile != "env.json" {
+		t.Errorf("Expected env to override the file, got %v", cfg.DataFile)
+	}
+	if cfg.LogLevel != "error" || cfg.IdleTimeout != time.Minute {
+		t.Errorf("Expected flags to override env, got %+v", cfg)
+	}
+	if cfg.TLSCert != "server.crt" {
+		t.Errorf("Expected default tls cert, got %v", cfg.TLSCert)
+	}
+
+	// -config flag takes precedence over CRAFTDEMO_CONFIG
+	cfg, err = loadConfig([]string{"-config", file.Name()}, noEnv)
+	if err != nil || cfg.Addr != ":8443" {
+		t.Errorf("Expected config file from flag, got %+v %v", cfg, err)
+	}
+
+	// failure cases
+	bad, _ := ioutil.TempFile("", "config*.yaml")
+	defer os.Remove(bad.Name())
+	bad.WriteString("adr: :8443\n")
+	bad.Close()
+
+	failures := [][]string{
+		{"-config", "no_file.yaml"},
+		{"-config", bad.Name()},
+		{"-read-timeout", "soon"},
+		{"-write-timeout", "-1s"},
+		{"-log-level", "loud"},
+		{"-log-format", "xml"},
+		{"-rate-limit", "-1"},
+		{"-rate-limit", "fast"},
+		{"-rate-burst", "0"},
+		{"-max-body-size", "0"},
+		{"-max-body-size", "1k"},
+		{"-tls-auto-generate", "maybe"},
+		{"-tls-auto-generate", "true", "-tls-hosts", " , "},
+		{"-access-log-format", "common"},
+		{"-addr", ""},
+		{"-unknown"},
+		{"extra"},
+	}
+	for _, args := range failures {
+		if _, err := loadConfig(args, noEnv); err == nil {
+			t.Errorf("%v: Expected error, got nil", args)
+		}
+	}
+	if _, err := loadConfig(nil, func(name string) string {
+		if name == "CRAFTDEMO_RELOAD_INTERVAL" {
+			return "often"
+		}
+		return ""
+	}); err == nil {
+		t.Errorf("Expected error for invalid env duration, got nil")
+	}
+}
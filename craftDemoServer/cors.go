@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headers of the api the browser may send and read
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-Match, If-None-Match, If-Modified-Since, X-Request-ID"
+	corsExposeHeaders = "ETag, Last-Modified, Location, Link, X-Total-Count, X-Request-ID, Retry-After"
+	corsMaxAge        = "600"
+)
+
+// corsHandler adds the CORS headers for the allowed origins, see CORS
+type corsHandler struct {
+	next    http.Handler
+	origins []string
+}
+
+/*
+CORS lets browsers on the allowed origins call targetMux, "*" allows every origin.
+Preflight requests are answered here, before auth, as browsers do not send credentials with them.
+It wraps the mux like RequestLogger and passes the route lookup through, so
+RequestLogger(CORS(mux)) still reports the routes of mux
+*/
+func CORS(targetMux http.Handler, origins []string) http.Handler {
+	return &corsHandler{next: targetMux, origins: origins}
+}
+
+func (c *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.allowed(origin) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Set("Access-Control-Allow-Origin", origin)
+
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+		header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		header.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	header.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+	c.next.ServeHTTP(w, r)
+}
+
+// Handler passes the route lookup on to the wrapped mux
+func (c *corsHandler) Handler(r *http.Request) (http.Handler, string) {
+	if mux, ok := c.next.(interface {
+		Handler(r *http.Request) (http.Handler, string)
+	}); ok {
+		return mux.Handler(r)
+	}
+	return c.next, ""
+}
+
+func (c *corsHandler) allowed(origin string) bool {
+	for _, allowed := range c.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	server := NewIncidentServer(&fakeStore{incidents: incidentsStore.Incidents{Name: "Fake"}})
+	server.auth = fakeAuth{"key": "alice"}
+	handler := CORS(server.Handler(), []string{"https://dashboard.example.com"})
+
+	// preflight is answered without credentials
+	req := httptest.NewRequest("OPTIONS", "/api/v1/incidents/INC1", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PATCH")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v", rr.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "https://dashboard.example.com",
+		"Access-Control-Allow-Methods": corsAllowMethods,
+		"Access-Control-Allow-Headers": corsAllowHeaders,
+		"Access-Control-Max-Age":       corsMaxAge,
+	}
+	for header, value := range expected {
+		if v := rr.Header().Get(header); v != value {
+			t.Errorf("Expected %s %q, got %q", header, value, v)
+		}
+	}
+
+	// actual requests expose the api headers
+	req = httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Authorization", "Bearer key")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Expose-Headers") != corsExposeHeaders {
+		t.Errorf("Expected 200 exposing the headers, got %v %v", rr.Code, rr.Header())
+	}
+
+	// other origins get no CORS headers
+	req = httptest.NewRequest("OPTIONS", "/api/v1/list/incidents", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for other origins, got %v", rr.Header())
+	}
+
+	// wildcard
+	handler = CORS(server.Handler(), []string{"*"})
+	req.Header.Set("Origin", "https://any.example.com")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://any.example.com" {
+		t.Errorf("Expected any origin to be allowed, got %v", rr.Header())
+	}
+
+	// the routes of the mux are still known to RequestLogger
+	req = httptest.NewRequest("GET", "/api/v1/incidents/INC1", nil)
+	if r := route(handler, req); r != "/api/v1/incidents/" {
+		t.Errorf("Expected route of the mux, got %q", r)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"os"
+)
+
+/*
+apiError is the json body sent along with every api failure
+Code is stable and meant for programs, Message for humans.
+RequestID matches the X-Request-ID header and the logs, Details depend on the code
+*/
+type apiError struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// writeError sends the error as json with the given status code
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails sends the error along with details as json with the given status code
+// The request id is taken from the response header set by RequestLogger
+func writeErrorDetails(w http.ResponseWriter, status int, code string, message string, details map[string]interface{}) {
+	js, _ := json.Marshal(apiError{
+		Code:      code,
+		Message:   message,
+		RequestID: w.Header().Get(RequestIDHeader),
+		Details:   details,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// notFoundHandler answers requests of paths without a route
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "not_found", "no such resource "+r.URL.Path)
+}
+
+// writeInternalError logs err and answers 500 without exposing it, it may contain file paths
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.WithField("request_id", w.Header().Get(RequestIDHeader)).Error("Request failed: ", err)
+	writeError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+}
+
+// writeBodyError answers requests whose body could not be decoded
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, "body_too_large",
+			fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit),
+			map[string]interface{}{"limit": tooLarge.Limit})
+		return
+	}
+	// unknown enum values are rejected while decoding
+	var verr *incidentsStore.ValidationError
+	if errors.As(err, &verr) {
+		writeStoreError(w, verr)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+}
+
+// writeStoreError maps the store errors to their status codes
+// Errors of the backing file are logged and answered without their details
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == incidentsStore.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	if err == incidentsStore.ErrClosed {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+		return
+	}
+	if err == incidentsStore.ErrConflict {
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+		return
+	}
+	if verr, ok := err.(*incidentsStore.ValidationError); ok {
+		writeErrorDetails(w, http.StatusBadRequest, "invalid_incident", verr.Error(),
+			map[string]interface{}{"field": verr.Field})
+		return
+	}
+	if terr, ok := err.(*incidentsStore.TransitionError); ok {
+		writeErrorDetails(w, http.StatusConflict, "invalid_transition", terr.Error(),
+			map[string]interface{}{"from": terr.From, "to": terr.To, "allowed": terr.Allowed})
+		return
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		log.WithField("request_id", w.Header().Get(RequestIDHeader)).Error("Incident store failed: ", err)
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "incident store is unavailable")
+		return
+	}
+	writeInternalError(w, err)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"fmt"
+	"model"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// filterField describes an incident field that can be filtered on
+type filterField struct {
+	// allowed values of the field, nil means any value is accepted
+	allowed []string
+	// value extracts the field from the incident
+	value func(inc incidentsStore.Incident) string
+}
+
+// query parameters accepted as filters, keyed by the json name of the field
+var filterFields = map[string]filterField{
+	"state": {model.States, func(inc incidentsStore.Incident) string {
+		return string(inc.State)
+	}},
+	"priority": {model.Priorities, func(inc incidentsStore.Incident) string {
+		return string(inc.Priority)
+	}},
+	"severity": {model.Severities, func(inc incidentsStore.Incident) string {
+		return string(inc.Severity)
+	}},
+	"assigned_to": {nil, func(inc incidentsStore.Incident) string {
+		return inc.AssignedTo
+	}},
+}
+
+/*
+parseFilter builds a predicate out of the filter query parameters
+?state=Open&priority=High,Critical
+Values of one field are OR'ed (comma separated or repeated parameter),
+different fields are AND'ed. Values are matched case insensitively.
+Unknown fields or values return an error.
+nil predicate is returned when there is nothing to filter
+*/
+func parseFilter(query url.Values) (incidentsStore.Predicate, error) {
+	var names []string
+	for name := range query {
+		// pagination parameters are handled by parsePage
+		if pageParams[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	// sort for deterministic error messages
+	sort.Strings(names)
+
+	type condition struct {
+		field  filterField
+		values []string
+	}
+	var conditions []condition
+
+	for _, name := range names {
+		field, ok := filterFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter field %q", name)
+		}
+		var values []string
+		for _, param := range query[name] {
+			for _, v := range strings.Split(param, ",") {
+				v = strings.TrimSpace(v)
+				if field.allowed != nil && !contains(field.allowed, v) {
+					return nil, fmt.Errorf("invalid %s %q, allowed values are %s",
+						name, v, strings.Join(field.allowed, ", "))
+				}
+				values = append(values, v)
+			}
+		}
+		conditions = append(conditions, condition{field, values})
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	return func(inc incidentsStore.Incident) bool {
+		for _, c := range conditions {
+			if !contains(c.values, c.field.value(inc)) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// contains reports whether v is in list, ignoring case
+func contains(list []string, v string) bool {
+	for _, elem := range list {
+		if strings.EqualFold(elem, v) {
+			return true
+		}
+	}
+	return false
+}
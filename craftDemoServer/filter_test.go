@@ -0,0 +1,101 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	incs := []incidentsStore.Incident{
+		{Number: "INC1", State: "Open", Priority: "High", Severity: "Low", AssignedTo: "Tom Brady"},
+		{Number: "INC2", State: "Open", Priority: "Critical", Severity: "High"},
+		{Number: "INC3", State: "Closed", Priority: "High", Severity: "High"},
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"state=Open", []string{"INC1", "INC2"}},
+		{"state=open&priority=High", []string{"INC1"}},
+		{"priority=High,Critical", []string{"INC1", "INC2", "INC3"}},
+		{"priority=High&priority=Critical&severity=High", []string{"INC2", "INC3"}},
+		{"assigned_to=Tom%20Brady", []string{"INC1"}},
+		{"assigned_to=", []string{"INC2", "INC3"}},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		match, err := parseFilter(query)
+		if err != nil {
+			t.Errorf("%s: Expected nil, got %v", test.query, err)
+			continue
+		}
+		var got []string
+		for _, inc := range incs {
+			if match(inc) {
+				got = append(got, inc.Number)
+			}
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: Expected %v, got %v", test.query, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: Expected %v, got %v", test.query, test.expected, got)
+			}
+		}
+	}
+
+	// no filter
+	match, err := parseFilter(url.Values{})
+	if match != nil || err != nil {
+		t.Errorf("Expected nil predicate, got %v", err)
+	}
+
+	// failure cases - unknown field and values
+	for _, query := range []string{"status=Open", "state=Done", "priority=High,", "severity=Urgent"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseFilter(values); err == nil {
+			t.Errorf("%s: Expected error, got nil", query)
+		}
+	}
+}
+
+func TestListHandlerFilter(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", State: "Open", Priority: "High"},
+			{Number: "INC2", State: "Closed", Priority: "High"},
+		},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents?state=Open", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"Name":"Fake","Report":[{"number":"INC1","assigned_to":"","description":"","state":"Open","priority":"High","severity":""}],"total":1}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/list/incidents?color=red", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"model"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *IncidentServer) listHandler(w http.ResponseWriter, r *http.Request) {
+
+	// build the filter and page out of the query parameters
+	match, err := parseFilter(r.URL.Query())
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	p, err := parsePage(r.URL.Query())
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	// get the matching incidents from the store
+
+	incidents, err := s.store.Query(match)
+
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	result := p.apply(incidents, r.URL)
+
+	// pagination headers, for clients that do not want to dig into the body
+	w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	var links []string
+	if result.Next != "" {
+		links = append(links, "<"+result.Next+`>; rel="next"`)
+	}
+	if result.Prev != "" {
+		links = append(links, "<"+result.Prev+`>; rel="prev"`)
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	s.writeCacheable(w, r, result)
+}
+
+// incidentsHandler serves the incidents collection, POST /api/v1/incidents creates an incident
+func (s *IncidentServer) incidentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+
+	var inc incidentsStore.Incident
+	if err := decodeIncident(r, &inc); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	// the lifecycle and its history are driven by the server
+	state := inc.State
+	if state == "" {
+		state = model.InitialState
+	}
+	inc.State, inc.History = "", nil
+	if err := inc.Transition(state, requester(r), time.Now()); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	created, err := s.store.Create(inc)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/incidents/"+created.Number)
+	w.Header().Set("ETag", incidentETag(created))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+/*
+incidentHandler serves a single incident
+GET    /api/v1/incidents/{number} returns the incident
+PUT    /api/v1/incidents/{number} replaces the incident
+PATCH  /api/v1/incidents/{number} updates only the fields present in the body
+DELETE /api/v1/incidents/{number} removes the incident
+PUT and PATCH require If-Match with the ETag of the incident, DELETE honors it if present
+*/
+func (s *IncidentServer) incidentHandler(w http.ResponseWriter, r *http.Request) {
+
+	number := strings.TrimPrefix(r.URL.Path, "/api/v1/incidents/")
+	if number == "" || strings.Contains(number, "/") {
+		writeError(w, http.StatusNotFound, "not_found", "no such resource "+r.URL.Path)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		inc, err := s.store.Get(number)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		s.writeCacheable(w, r, inc)
+
+	case http.MethodPut, http.MethodPatch:
+		stored, err := s.store.Get(number)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if !checkIfMatch(w, r, stored, true) {
+			return
+		}
+		var inc incidentsStore.Incident
+		if r.Method == http.MethodPatch {
+			// decode the patch over the stored incident
+			inc = *stored
+		}
+		if err := decodeIncident(r, &inc); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		// the number in the path identifies the incident
+		if inc.Number == "" {
+			inc.Number = number
+		}
+		if inc.Number != number {
+			writeError(w, http.StatusBadRequest, "invalid_body", "number "+inc.Number+" does not match "+number)
+			return
+		}
+		// state changes have to follow the lifecycle, the history and revision are kept by the server
+		state := inc.State
+		inc.State, inc.History, inc.Revision = stored.State, stored.History, stored.Revision
+		if state != "" {
+			if err := inc.Transition(state, requester(r), time.Now()); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+		updated, err := s.store.Update(inc)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("ETag", incidentETag(updated))
+		writeJSON(w, http.StatusOK, updated)
+
+	case http.MethodDelete:
+		stored, err := s.store.Get(number)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if !checkIfMatch(w, r, stored, false) {
+			return
+		}
+		if err := s.store.Delete(number); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+	}
+}
+
+// decodeIncident reads the json body into inc, fields not in the schema and
+// anything after the incident are rejected
+func decodeIncident(r *http.Request, inc *incidentsStore.Incident) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(inc); err != nil {
+		return err
+	}
+	_, err := dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return errors.New("body must hold a single json object")
+}
+
+// writeJSON sends v as json with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	// Set the content-type header to json
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+/*
+writeCacheable sends v as json along with a content hash ETag and the
+modification time of the store revision as Last-Modified
+Conditional requests matching them are answered with 304 Not Modified
+*/
+func (s *IncidentServer) writeCacheable(w http.ResponseWriter, r *http.Request, v interface{}) {
+	js, err := json.Marshal(v)
+
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	etag := contentETag(js)
+	w.Header().Set("ETag", etag)
+
+	// http dates have a resolution of seconds
+	var modified time.Time
+	if rev, err := s.store.Revision(); err == nil && !rev.Modified.IsZero() {
+		modified = rev.Modified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Set the content-type header to json
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// contentETag returns the strong ETag of a response body
+func contentETag(js []byte) string {
+	sum := sha256.Sum256(js)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// incidentETag returns the ETag GET /api/v1/incidents/{number} sends for inc
+func incidentETag(inc *incidentsStore.Incident) string {
+	js, _ := json.Marshal(inc)
+	return contentETag(js)
+}
+
+/*
+checkIfMatch makes sure the client edits the current version of the incident
+The If-Match ETags are compared with the stored incident, 412 is sent when none match.
+If required, a missing If-Match is answered with 428
+It returns false when a response has been sent
+*/
+func checkIfMatch(w http.ResponseWriter, r *http.Request, stored *incidentsStore.Incident, required bool) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		if required {
+			writeError(w, http.StatusPreconditionRequired, "precondition_required",
+				"If-Match with the ETag of incident "+stored.Number+" is required")
+			return false
+		}
+		return true
+	}
+	etag := incidentETag(stored)
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses strong comparison
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	w.Header().Set("ETag", etag)
+	writeError(w, http.StatusPreconditionFailed, "precondition_failed",
+		"incident "+stored.Number+" was modified, fetch it again and retry")
+	return false
+}
+
+/*
+notModified reports whether the client already has the current representation
+If-None-Match is checked first, If-Modified-Since only when there is no If-None-Match
+*/
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			// weak comparison is enough for GET
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !modified.After(t)
+	}
+	return false
+}
+
+// requester returns who made the request, it is recorded in the incident history
+// Without auth or client certificate the remote address is all we know
+func requester(r *http.Request) string {
+	if name := user(r); name != "" {
+		return name
+	}
+	if name := peerIdentity(r); name != "" {
+		return name
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncidentsWriteHandlers(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{{Number: "INC1", Description: "Login is not working",
+			State: "Open", Priority: "High", Severity: "High"}},
+	}}
+	server := NewIncidentServer(store)
+	server.auth = fakeAuth{"tom-key": "tom"}
+	handler := server.Handler()
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		// create
+		{"POST", "/api/v1/incidents", `{"description":"VM is hung","priority":"Low","severity":"Low"}`, http.StatusCreated},
+		{"POST", "/api/v1/incidents", `{"description":"VM is hung","state":"Open","priority":"Low","severity":"Low"}`, http.StatusConflict},
+		{"POST", "/api/v1/incidents", `{"description":"VM is hung","priority":"Urgent","severity":"Low"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/incidents", `{"description":"VM is hung","color":"red"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/incidents", `not json`, http.StatusBadRequest},
+		{"GET", "/api/v1/incidents", ``, http.StatusMethodNotAllowed},
+		// replace
+		{"PUT", "/api/v1/incidents/INC1", `{"description":"Login works","state":"Closed","priority":"High","severity":"High"}`, http.StatusConflict},
+		{"PUT", "/api/v1/incidents/INC1", `{"description":"Login works","state":"resolved","priority":"High","severity":"High"}`, http.StatusOK},
+		{"PUT", "/api/v1/incidents/INC1", `{"number":"INC2","description":"Login works","state":"Resolved","priority":"High","severity":"High"}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/incidents/INC9", `{"description":"Login works","state":"Resolved","priority":"High","severity":"High"}`, http.StatusNotFound},
+		// partial update
+		{"PATCH", "/api/v1/incidents/INC1", `{"assigned_to":"Tom Brady"}`, http.StatusOK},
+		{"PATCH", "/api/v1/incidents/INC1", `{"severity":"Huge"}`, http.StatusBadRequest},
+		{"PATCH", "/api/v1/incidents/INC1", `{"state":"Done"}`, http.StatusBadRequest},
+		{"PATCH", "/api/v1/incidents/INC1", `{"state":"Closed"}`, http.StatusOK},
+		{"PATCH", "/api/v1/incidents/INC1", `{"state":"In Progress"}`, http.StatusConflict},
+		{"PATCH", "/api/v1/incidents/INC9", `{"assigned_to":"Tom Brady"}`, http.StatusNotFound},
+		// delete
+		{"DELETE", "/api/v1/incidents/INC2", ``, http.StatusNoContent},
+		{"DELETE", "/api/v1/incidents/INC2", ``, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("Authorization", "Bearer tom-key")
+		// edit the current version of the incident
+		if test.method != "POST" {
+			get := httptest.NewRecorder()
+			getReq := httptest.NewRequest("GET", test.path, nil)
+			getReq.Header.Set("Authorization", "Bearer tom-key")
+			handler.ServeHTTP(get, getReq)
+			if etag := get.Header().Get("ETag"); etag != "" {
+				req.Header.Set("If-Match", etag)
+			}
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("%s %s %s: handler returned wrong status code: got %v want %v",
+				test.method, test.path, test.body, status, test.expected)
+		}
+	}
+
+	// the patch kept the replaced fields and the transitions were recorded
+	inc, _ := store.Get("INC1")
+	if inc.AssignedTo != "Tom Brady" || inc.State != "Closed" || inc.Description != "Login works" {
+		t.Errorf("Expected patched incident, got %+v", inc)
+	}
+	if len(inc.History) != 2 {
+		t.Fatalf("Expected 2 transitions, got %+v", inc.History)
+	}
+	if h := inc.History[1]; h.From != "Resolved" || h.To != "Closed" || h.By != "tom" || h.At.IsZero() {
+		t.Errorf("Expected Resolved -> Closed by tom, got %+v", h)
+	}
+}
+
+func TestCreateHandlerLocation(t *testing.T) {
+	store := &fakeStore{}
+	handler := NewIncidentServer(store).Handler()
+
+	body := `{"description":"VM is hung","priority":"Low","severity":"Low"}`
+	req := httptest.NewRequest("POST", "/api/v1/incidents", strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/api/v1/incidents/INC1" {
+		t.Errorf("Expected location /api/v1/incidents/INC1, got %s", loc)
+	}
+	var inc incidentsStore.Incident
+	if err := json.Unmarshal(rr.Body.Bytes(), &inc); err != nil {
+		t.Fatalf("Expected json body, got %v", err)
+	}
+	if inc.Number != "INC1" || inc.State != "New" {
+		t.Errorf("Expected new incident INC1, got %+v", inc)
+	}
+	// without auth the remote address is recorded
+	if len(inc.History) != 1 || inc.History[0].To != "New" || inc.History[0].By != "10.0.0.1:1234" {
+		t.Errorf("Expected creation in history, got %+v", inc.History)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	modified := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	store := &fakeStore{
+		incidents: incidentsStore.Incidents{
+			Name:   "Fake",
+			Report: []incidentsStore.Incident{{Number: "INC1", State: "Open"}},
+		},
+		revision: incidentsStore.Revision{Number: 1, Modified: modified},
+	}
+	handler := NewIncidentServer(store).Handler()
+
+	for _, path := range []string{"/api/v1/list/incidents", "/api/v1/incidents/INC1"} {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		etag := rr.Header().Get("ETag")
+		if rr.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: Expected 200 with ETag, got %v %q", path, rr.Code, etag)
+		}
+		if lm := rr.Header().Get("Last-Modified"); lm != "Wed, 01 May 2019 10:00:00 GMT" {
+			t.Errorf("%s: Expected Last-Modified, got %q", path, lm)
+		}
+
+		tests := []struct {
+			header   string
+			value    string
+			expected int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-None-Match", `"other", ` + etag, http.StatusNotModified},
+			{"If-None-Match", "W/" + etag, http.StatusNotModified},
+			{"If-None-Match", `"other"`, http.StatusOK},
+			{"If-Modified-Since", "Wed, 01 May 2019 10:00:00 GMT", http.StatusNotModified},
+			{"If-Modified-Since", "Wed, 01 May 2019 09:59:59 GMT", http.StatusOK},
+		}
+		for _, test := range tests {
+			req = httptest.NewRequest("GET", path, nil)
+			req.Header.Set(test.header, test.value)
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expected {
+				t.Errorf("%s %s: %s: handler returned wrong status code: got %v want %v",
+					path, test.header, test.value, rr.Code, test.expected)
+			}
+			if rr.Code == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("%s: Expected empty body with 304, got %s", path, rr.Body.String())
+			}
+		}
+	}
+
+	// a change of the content changes the ETag
+	req := httptest.NewRequest("GET", "/api/v1/incidents/INC1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+	store.incidents.Report[0].State = "Closed"
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag, got %v", rr.Code)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{{Number: "INC1", Description: "Login is not working",
+			State: "Open", Priority: "High", Severity: "High", Revision: 1}},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest("GET", "/api/v1/incidents/INC1", nil))
+	etag := get.Header().Get("ETag")
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/incidents/INC1", strings.NewReader(`{"assigned_to":"Tom Brady"}`))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// missing If-Match
+	if rr := patch(""); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+
+	// current version, the response carries the new ETag and revision
+	rr := patch(etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	newETag := rr.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag, got %q", newETag)
+	}
+	if inc, _ := store.Get("INC1"); inc.Revision != 2 {
+		t.Errorf("Expected revision 2, got %v", inc.Revision)
+	}
+
+	// the second editor with the old ETag is rejected
+	rr = patch(etag)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	if rr.Header().Get("ETag") != newETag {
+		t.Errorf("Expected current ETag %s, got %s", newETag, rr.Header().Get("ETag"))
+	}
+
+	// any version
+	if rr := patch("*"); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// delete with a stale ETag
+	req := httptest.NewRequest("DELETE", "/api/v1/incidents/INC1", nil)
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	// the store detects updates racing between the check and the write
+	_, err := store.Update(incidentsStore.Incident{Number: "INC1", Description: "a",
+		State: "Open", Priority: "High", Severity: "High", Revision: 1})
+	if err != incidentsStore.ErrConflict {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrConflict, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"runtime"
+)
+
+// Build info, injected by the Makefile
+// go build -ldflags "-X main.Version=0.0.1 -X main.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// status is the body of the probes
+type status struct {
+	Status string `json:"status"`
+}
+
+// buildInfo is the body of /version
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// healthzHandler answers as long as the process is able to serve requests
+func (s *IncidentServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, status{"ok"})
+}
+
+// readyzHandler answers 200 only when the store data is available and valid
+// e.g. it fails with 503 when incidents.json is missing or corrupt
+func (s *IncidentServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Check(); err != nil {
+		log.WithField("request_id", w.Header().Get(RequestIDHeader)).Warn("Not ready: ", err)
+		writeError(w, http.StatusServiceUnavailable, "not_ready", "incident store is not ready")
+		return
+	}
+	writeJSON(w, http.StatusOK, status{"ready"})
+}
+
+// versionHandler returns the build info of the server
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo{Version, Commit, runtime.Version()})
+}
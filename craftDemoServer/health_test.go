@@ -0,0 +1,54 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore/servicenowStore"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		file     string
+		path     string
+		expected int
+	}{
+		{"incidents.json", "/healthz", http.StatusOK},
+		{"incidents.json", "/readyz", http.StatusOK},
+		{"no_file.json", "/healthz", http.StatusOK},
+		{"no_file.json", "/readyz", http.StatusServiceUnavailable},
+		{"incidentsStore/servicenowStore/no_json.json", "/readyz", http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		snst, _ := servicenowStore.Init(test.file)
+		handler := NewIncidentServer(snst).Handler()
+
+		req := httptest.NewRequest("GET", test.path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v",
+				test.file, test.path, status, test.expected)
+		}
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	handler := NewIncidentServer(&fakeStore{}).Handler()
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var info buildInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Expected json body, got %v", err)
+	}
+	if info.Version != Version || info.Commit != Commit || info.GoVersion != runtime.Version() {
+		t.Errorf("Expected build info, got %+v", info)
+	}
+}
@@ -0,0 +1,75 @@
+package incidentsStore
+
+import (
+	"errors"
+	"model"
+	"time"
+)
+
+// Returned by Get, Update and Delete when no incident matches the given number
+var ErrNotFound = errors.New("incident not found")
+
+// Returned by Update when the incident was changed since the revision it is based on
+var ErrConflict = errors.New("incident was modified by someone else")
+
+// Returned by writes after the store has been closed
+var ErrClosed = errors.New("incident store is closed")
+
+// Incident types are defined by the shared model package, so client and server agree on them
+type (
+	Incidents       = model.Incidents
+	Incident        = model.Incident
+	Transition      = model.Transition
+	ValidationError = model.ValidationError
+	TransitionError = model.TransitionError
+)
+
+// Revision identifies a version of the store content
+type Revision struct {
+	Number   uint64    // bumped on every change of the store
+	Modified time.Time // when the content was last changed
+}
+
+// Stats of the file loads of a store, used for monitoring
+type Stats struct {
+	Reloads     uint64    // successful loads of the data file
+	LastReload  time.Time // time of the last successful load
+	ParseErrors uint64    // loads failed because the data could not be parsed
+}
+
+// StatsReporter is implemented by stores loading their data from a file
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Predicate decides whether an incident is part of a Query result
+type Predicate func(inc Incident) bool
+
+/*
+IncidentStore is implemented by every incident backend (servicenow file store etc).
+The server only talks to this interface, so backends can be picked by config
+and tests can inject fakes
+*/
+type IncidentStore interface {
+	// List returns the entire incidents report
+	List() (*Incidents, error)
+	// Get returns a single incident by number or ErrNotFound
+	Get(number string) (*Incident, error)
+	// Query returns the report narrowed to the incidents matching the predicate,
+	// in report order
+	Query(match Predicate) (*Incidents, error)
+	// Create validates the incident, allocates its number and stores it with revision 1
+	Create(inc Incident) (*Incident, error)
+	// Update validates the incident and replaces the stored one with the same number
+	// inc.Revision must be the stored revision, otherwise ErrConflict is returned.
+	// The stored incident gets the next revision
+	Update(inc Incident) (*Incident, error)
+	// Delete removes the incident with the given number
+	Delete(number string) error
+	// Revision returns the current revision of the store content
+	Revision() (*Revision, error)
+	// Check reports whether the backing data is available and valid
+	Check() error
+	// Close waits for pending writes and releases the store, later writes fail with ErrClosed
+	Close() error
+}
@@ -1,32 +1,67 @@
 package servicenowStore
 
 import (
+	"craftDemoServer/incidentsStore"
 	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // The store obj has File as we get the data from a file
+// The file is parsed once and kept in memory along with an index by incident number
+// Writes replace the snapshot and are persisted back to the file atomically
+// Watch reloads the snapshot when the file is changed by someone else
 type ServicenowStore struct {
 	File string
+
+	mu     sync.RWMutex
+	snap   *snapshot
+	stop   chan struct{}
+	closed bool
+	stats  incidentsStore.Stats
+
+	// version of the file that failed to parse, so it is not parsed and counted again
+	failedModTime time.Time
+	failedSize    int64
+	failedErr     error
 }
 
-// Entire Incidents object
-type Incidents struct {
-	Name   string     `json:"Name"`
-	Report []Incident `json:"Report"`
+// snapshot is the parsed content of the store file
+type snapshot struct {
+	file      string
+	revision  uint64    // counts the snapshots of the store
+	modTime   time.Time // modification time and size of the file when it was read
+	size      int64
+	incidents Incidents
+	index     map[string]int // incident number -> position in the report
+	next      int            // number of the next created incident
 }
 
-// Individual incident object
-type Incident struct {
-	Number      string `json:"number"`
-	AssignedTo  string `json:"assigned_to"`
-	Description string `json:"description"`
-	State       string `json:"state"`
-	Priority    string `json:"priority"`
-	Severity    string `json:"severity"`
+/*
+storeFile is the content of the store file
+NextNumber is the high-water mark of the allocated numbers, it is kept in the file
+so numbers of deleted incidents are never given out again
+*/
+type storeFile struct {
+	Incidents
+	NextNumber int `json:"next_number,omitempty"`
 }
 
+// Incident types are shared by all the stores
+type Incidents = incidentsStore.Incidents
+type Incident = incidentsStore.Incident
+
+// make sure ServicenowStore satisfies the store interfaces
+var _ incidentsStore.IncidentStore = (*ServicenowStore)(nil)
+var _ incidentsStore.StatsReporter = (*ServicenowStore)(nil)
+
 /*
 Initializes the servicenow object with fileStore to read
 */
@@ -43,12 +78,11 @@ func Init(file string) (*ServicenowStore, error) {
 }
 
 /*
-Add GetList method to ServicenowStore
-Read the file, extract json objects and map it to required struct
-Return final json
+load reads the file, extracts json objects and maps them to required struct
+It also builds the index used by Get
 */
-func (snst *ServicenowStore) GetList() (*[]byte, error) {
-	jsonFile, err := os.Open(snst.File)
+func load(file string) (*snapshot, error) {
+	jsonFile, err := os.Open(file)
 	// if we os.Open returns an error then handle it
 	if err != nil {
 		return nil, err
@@ -57,26 +91,399 @@ func (snst *ServicenowStore) GetList() (*[]byte, error) {
 	// defer the closing of our jsonFile so that we can parse it later on
 	defer jsonFile.Close()
 
+	info, err := jsonFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
 	byteValue, err := ioutil.ReadAll(jsonFile)
 	if err != nil {
 		return nil, err
 	}
 
-	var incidents Incidents
+	var content storeFile
 
 	// encode bytes to incidents struct object by mapping required fields
-	err = json.Unmarshal(byteValue, &incidents)
+	err = json.Unmarshal(byteValue, &content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	snap := newSnapshot(file, content.Incidents, content.NextNumber)
+	snap.modTime, snap.size = info.ModTime(), info.Size()
+	return snap, nil
+}
+
+// newSnapshot indexes the incidents by number
+// the next number is next, or the one following the highest number in the report if that is higher
+func newSnapshot(file string, incidents Incidents, next int) *snapshot {
+	snap := snapshot{file: file, incidents: incidents, next: next}
+	snap.index = make(map[string]int, len(incidents.Report))
+	for i, inc := range incidents.Report {
+		snap.index[inc.Number] = i
+		n, err := strconv.Atoi(strings.TrimPrefix(inc.Number, "INC"))
+		if err == nil && n >= snap.next {
+			snap.next = n + 1
+		}
+	}
+	if snap.next < 1 {
+		snap.next = 1
+	}
+	return &snap
+}
+
+/*
+save writes the incidents to file atomically
+The json is written to a temp file in the same directory which is then renamed
+over file, so readers never see a partially written store
+*/
+func save(file string, content *storeFile) (err error) {
+	js, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	// remove the temp file on failure
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// keep the permissions of the existing file
+	if info, statErr := os.Stat(file); statErr == nil {
+		if err = tmp.Chmod(info.Mode()); err != nil {
+			return err
+		}
+	}
+	if _, err = tmp.Write(js); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// snapshot returns the parsed store file, loading it on first use
+// or when File has been pointed to another file
+func (snst *ServicenowStore) snapshot() (*snapshot, error) {
+	snst.mu.RLock()
+	snap := snst.snap
+	snst.mu.RUnlock()
+	if snap != nil && snap.file == snst.File {
+		return snap, nil
+	}
+
+	snst.mu.Lock()
+	defer snst.mu.Unlock()
+
+	return snst.loadLocked()
+}
+
+/*
+Reload parses the file again if its modification time or size changed since
+it was read. If the new content can not be parsed, the error is returned
+and the last good snapshot keeps being served. The error is remembered, so
+the same bad version is reported without parsing and counting it again
+*/
+func (snst *ServicenowStore) Reload() error {
+	snst.mu.Lock()
+	defer snst.mu.Unlock()
+
+	return snst.reloadLocked()
+}
+
+// reloadLocked is Reload for callers already holding mu
+func (snst *ServicenowStore) reloadLocked() error {
+	if snst.snap == nil || snst.snap.file != snst.File {
+		_, err := snst.loadLocked()
+		return err
+	}
+
+	info, err := os.Stat(snst.File)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(snst.snap.modTime) && info.Size() == snst.snap.size {
+		return nil
+	}
+	if snst.failedErr != nil && info.ModTime().Equal(snst.failedModTime) && info.Size() == snst.failedSize {
+		return snst.failedErr
+	}
+
+	if err := snst.loadFileLocked(); err != nil {
+		snst.failedModTime, snst.failedSize, snst.failedErr = info.ModTime(), info.Size(), err
+		return err
+	}
+	snst.failedErr = nil
+	log.Info("Reloaded ", snst.File)
+	return nil
+}
+
+// Stats returns the load stats of the file
+func (snst *ServicenowStore) Stats() incidentsStore.Stats {
+	snst.mu.RLock()
+	defer snst.mu.RUnlock()
+
+	return snst.stats
+}
+
+/*
+Check makes sure the file exists and can be parsed
+It reloads the file if it changed, so an unchanged file is not parsed again
+*/
+func (snst *ServicenowStore) Check() error {
+	return snst.Reload()
+}
+
+/*
+Watch checks the file for changes every interval and reloads it
+Failed reloads are logged, the last good snapshot is kept
+Close stops watching
+*/
+func (snst *ServicenowStore) Watch(interval time.Duration) {
+	snst.mu.Lock()
+	defer snst.mu.Unlock()
+
+	if snst.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	snst.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := snst.Reload(); err != nil {
+					log.Warn("Reloading ", snst.File, " failed, serving last good snapshot: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
 
+// Close stops watching the file
+// Writes are persisted before they return, so holding mu is enough to wait for pending ones
+func (snst *ServicenowStore) Close() error {
+	snst.mu.Lock()
+	defer snst.mu.Unlock()
+
+	snst.closed = true
+	if snst.stop != nil {
+		close(snst.stop)
+		snst.stop = nil
+	}
+	return nil
+}
+
+// loadLocked is snapshot for callers already holding mu
+func (snst *ServicenowStore) loadLocked() (*snapshot, error) {
+	if snst.snap == nil || snst.snap.file != snst.File {
+		if err := snst.loadFileLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return snst.snap, nil
+}
+
+// loadFileLocked reads File into a new snapshot and updates the stats, mu must be held
+func (snst *ServicenowStore) loadFileLocked() error {
+	snap, err := load(snst.File)
+	if err != nil {
+		// anything but a missing or unreadable file is a parse error
+		if _, ok := err.(*os.PathError); !ok {
+			snst.stats.ParseErrors++
+		}
+		return err
+	}
+	snst.setLocked(snap)
+	snst.stats.Reloads++
+	snst.stats.LastReload = time.Now()
+	return nil
+}
+
+// setLocked swaps in the new snapshot with the next revision, mu must be held
+func (snst *ServicenowStore) setLocked(snap *snapshot) {
+	if snst.snap != nil {
+		snap.revision = snst.snap.revision + 1
+	} else {
+		snap.revision = 1
+	}
+	snst.snap = snap
+}
+
+/*
+Revision returns the revision of the current snapshot
+Modified is the modification time of the file
+*/
+func (snst *ServicenowStore) Revision() (*incidentsStore.Revision, error) {
+	snap, err := snst.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &incidentsStore.Revision{Number: snap.revision, Modified: snap.modTime}, nil
+}
+
+/*
+Add List method to ServicenowStore
+Returns a copy of the report, so callers are free to modify it
+*/
+func (snst *ServicenowStore) List() (*Incidents, error) {
+	return snst.Query(nil)
+}
+
+/*
+Get returns the incident with the given number
+incidentsStore.ErrNotFound is returned if there is no such incident
+*/
+func (snst *ServicenowStore) Get(number string) (*Incident, error) {
+	snap, err := snst.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	i, ok := snap.index[number]
+	if !ok {
+		return nil, incidentsStore.ErrNotFound
+	}
+	inc := snap.incidents.Report[i]
+	return &inc, nil
+}
+
+/*
+Query returns the report with only the incidents for which match returns true
+nil match returns every incident
+*/
+func (snst *ServicenowStore) Query(match incidentsStore.Predicate) (*Incidents, error) {
+	snap, err := snst.snapshot()
 	if err != nil {
 		return nil, err
 	}
+	result := Incidents{Name: snap.incidents.Name, Report: []Incident{}}
+	for _, inc := range snap.incidents.Report {
+		if match == nil || match(inc) {
+			result.Report = append(result.Report, inc)
+		}
+	}
+	return &result, nil
+}
+
+/*
+modify applies change to a copy of the report, persists the result and
+swaps in the new snapshot. Writes are serialized by mu and readers keep using
+the old snapshot until the new one is saved.
+The file is reloaded first if it was changed by someone else, so their edit is
+not overwritten. If it can not be parsed, the write fails.
+change gets the number of the next created incident, it is never lowered
+*/
+func (snst *ServicenowStore) modify(change func(report []Incident, next int) ([]Incident, error)) error {
+	snst.mu.Lock()
+	defer snst.mu.Unlock()
+
+	if snst.closed {
+		return incidentsStore.ErrClosed
+	}
+
+	if err := snst.reloadLocked(); err != nil {
+		return err
+	}
+	snap := snst.snap
+
+	report := make([]Incident, len(snap.incidents.Report))
+	copy(report, snap.incidents.Report)
+
+	report, err := change(report, snap.next)
+	if err != nil {
+		return err
+	}
+
+	incidents := Incidents{Name: snap.incidents.Name, Report: report}
+	newSnap := newSnapshot(snap.file, incidents, snap.next)
+	if err = save(snap.file, &storeFile{incidents, newSnap.next}); err != nil {
+		return err
+	}
+	// remember our own write, so that Watch does not reload it
+	if info, err := os.Stat(snap.file); err == nil {
+		newSnap.modTime, newSnap.size = info.ModTime(), info.Size()
+	}
+	snst.setLocked(newSnap)
+	return nil
+}
 
-	// decode the json to string
-	js, err := json.Marshal(&incidents)
+/*
+Create validates the incident, allocates the next INCnnnn number and persists it
+Numbers are allocated by the store, so inc must not carry one
+*/
+func (snst *ServicenowStore) Create(inc Incident) (*Incident, error) {
+	if inc.Number != "" {
+		return nil, &incidentsStore.ValidationError{Field: "number", Message: "is allocated by the server"}
+	}
+	if err := inc.Validate(); err != nil {
+		return nil, err
+	}
+	err := snst.modify(func(report []Incident, next int) ([]Incident, error) {
+		inc.Number = fmt.Sprintf("INC%04d", next)
+		inc.Revision = 1
+		return append(report, inc), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inc, nil
+}
 
+/*
+Update validates the incident and replaces the stored incident with the same number
+The update is rejected with ErrConflict unless inc.Revision is the stored revision,
+so concurrent updates based on the same revision can not overwrite each other
+*/
+func (snst *ServicenowStore) Update(inc Incident) (*Incident, error) {
+	if err := inc.Validate(); err != nil {
+		return nil, err
+	}
+	err := snst.modify(func(report []Incident, next int) ([]Incident, error) {
+		for i := range report {
+			if report[i].Number == inc.Number {
+				if report[i].Revision != inc.Revision {
+					return nil, incidentsStore.ErrConflict
+				}
+				inc.Revision++
+				report[i] = inc
+				return report, nil
+			}
+		}
+		return nil, incidentsStore.ErrNotFound
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &inc, nil
+}
 
-	return &js, nil
+/*
+Delete removes the incident with the given number
+*/
+func (snst *ServicenowStore) Delete(number string) error {
+	return snst.modify(func(report []Incident, next int) ([]Incident, error) {
+		for i := range report {
+			if report[i].Number == number {
+				return append(report[:i], report[i+1:]...), nil
+			}
+		}
+		return nil, incidentsStore.ErrNotFound
+	})
 }
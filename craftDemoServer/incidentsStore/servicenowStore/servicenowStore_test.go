@@ -1,26 +1,37 @@
 package servicenowStore_test
 
 import (
+	"craftDemoServer/incidentsStore"
 	"craftDemoServer/incidentsStore/servicenowStore"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
-func TestGetList(t *testing.T) {
+func TestList(t *testing.T) {
 	//var err error
 
-	// Success case - GetList should return expected json
+	// Success case - List should return expected incidents
 	var err error
 	file := "incidents_test.json"
 	snst, err := servicenowStore.Init(file)
 
 	expectedStr := `{"Name":"ServiceNowQuery","Report":[{"number":"INC1234","assigned_to":"","description":"","state":"","priority":"","severity":""}]}`
-	js, err := snst.GetList()
+	incidents, err := snst.List()
 
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
 
-	jsStr := string(*js)
+	js, err := json.Marshal(incidents)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	jsStr := string(js)
 
 	if jsStr != expectedStr {
 		t.Errorf("Expected %s, got %s", expectedStr, jsStr)
@@ -28,7 +39,7 @@ func TestGetList(t *testing.T) {
 
 	// failure case - file not found
 	snst.File = "file_not_found.json"
-	js, err = snst.GetList()
+	_, err = snst.List()
 
 	if err == nil {
 		t.Errorf("Expected error, got %v", err)
@@ -36,9 +47,369 @@ func TestGetList(t *testing.T) {
 
 	// failure case - no json data
 	snst.File = "no_json.json"
-	js, err = snst.GetList()
+	_, err = snst.List()
 
 	if err == nil {
 		t.Errorf("Expected error, got %v", err)
 	}
 }
+
+func TestGet(t *testing.T) {
+	snst, _ := servicenowStore.Init("incidents_test.json")
+
+	// success case
+	inc, err := snst.Get("INC1234")
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if inc == nil || inc.Number != "INC1234" {
+		t.Errorf("Expected INC1234, got %v", inc)
+	}
+
+	// failure case - unknown number
+	_, err = snst.Get("INC0000")
+	if err != incidentsStore.ErrNotFound {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrNotFound, err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	snst, _ := servicenowStore.Init("incidents_test.json")
+
+	// nil predicate returns everything
+	incs, err := snst.Query(nil)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if len(incs.Report) != 1 {
+		t.Errorf("Expected 1 incident, got %v", len(incs.Report))
+	}
+
+	// predicate with no match returns empty slice
+	incs, err = snst.Query(func(inc servicenowStore.Incident) bool {
+		return inc.State == "Open"
+	})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if len(incs.Report) != 0 {
+		t.Errorf("Expected 0 incidents, got %v", len(incs.Report))
+	}
+}
+
+func TestSnapshotIsCopied(t *testing.T) {
+	snst, _ := servicenowStore.Init("incidents_test.json")
+
+	// modifying the returned values must not change the store
+	incidents, _ := snst.List()
+	incidents.Report[0].State = "Closed"
+	inc, _ := snst.Get("INC1234")
+	if inc.State != "" {
+		t.Errorf("Expected empty state, got %v", inc.State)
+	}
+	inc.State = "Closed"
+	inc, _ = snst.Get("INC1234")
+	if inc.State != "" {
+		t.Errorf("Expected empty state, got %v", inc.State)
+	}
+}
+
+// tempStore copies the given file into a temp dir and opens a store on it
+func tempStore(t *testing.T, file string) (*servicenowStore.ServicenowStore, func()) {
+	dir, err := ioutil.TempDir("", "servicenowStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, filepath.Base(file))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	snst, _ := servicenowStore.Init(path)
+	return snst, func() { os.RemoveAll(dir) }
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	// create allocates the next number
+	inc, err := snst.Create(servicenowStore.Incident{Description: "VM is hung",
+		State: "Open", Priority: "high", Severity: "Low"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if inc.Number != "INC1235" || inc.Priority != "High" || inc.Revision != 1 {
+		t.Errorf("Expected INC1235 with High priority at revision 1, got %+v", inc)
+	}
+
+	// create rejects numbers and invalid incidents
+	if _, err := snst.Create(servicenowStore.Incident{Number: "INC1", Description: "a",
+		State: "Open", Priority: "High", Severity: "Low"}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if _, err := snst.Create(servicenowStore.Incident{Description: "a"}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	// the write is persisted, a fresh store reads it back
+	reopened, _ := servicenowStore.Init(snst.File)
+	if _, err := reopened.Get("INC1235"); err != nil {
+		t.Errorf("Expected persisted incident, got %v", err)
+	}
+
+	// update
+	inc.State = "Closed"
+	if _, err := snst.Update(*inc); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	got, _ := snst.Get("INC1235")
+	if got.State != "Closed" || got.Revision != 2 {
+		t.Errorf("Expected Closed at revision 2, got %v %v", got.State, got.Revision)
+	}
+
+	// update based on a stale revision
+	if _, err := snst.Update(*inc); err != incidentsStore.ErrConflict {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrConflict, err)
+	}
+	inc.Number = "INC9999"
+	if _, err := snst.Update(*inc); err != incidentsStore.ErrNotFound {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrNotFound, err)
+	}
+
+	// delete
+	if err := snst.Delete("INC1235"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := snst.Delete("INC1235"); err != incidentsStore.ErrNotFound {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrNotFound, err)
+	}
+	reopened, _ = servicenowStore.Init(snst.File)
+	incidents, _ := reopened.List()
+	if len(incidents.Report) != 1 {
+		t.Errorf("Expected 1 incident, got %v", len(incidents.Report))
+	}
+
+	// numbers of deleted incidents are not given out again, also after a restart
+	inc, err = reopened.Create(servicenowStore.Incident{Description: "Disk full",
+		State: "Open", Priority: "Low", Severity: "Low"})
+	if err != nil || inc.Number != "INC1236" {
+		t.Errorf("Expected INC1236, got %+v %v", inc, err)
+	}
+
+	// no temp files are left behind
+	files, _ := ioutil.ReadDir(filepath.Dir(snst.File))
+	if len(files) != 1 {
+		t.Errorf("Expected only the store file, got %v files", len(files))
+	}
+}
+
+func TestReload(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	incidents, err := snst.List()
+	if err != nil || len(incidents.Report) != 1 {
+		t.Fatalf("Expected 1 incident, got %v", err)
+	}
+
+	// unchanged file is not reloaded
+	if err := snst.Reload(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// changed file is picked up
+	changed := `{"Name":"ServiceNowQuery","Report":[{"number":"INC1"},{"number":"INC2"}]}`
+	if err := ioutil.WriteFile(snst.File, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+	if err := snst.Reload(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, err := snst.Get("INC2"); err != nil {
+		t.Errorf("Expected reloaded INC2, got %v", err)
+	}
+
+	// corrupt file keeps the last good snapshot
+	if err := ioutil.WriteFile(snst.File, []byte(`{"Name":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+	if err := snst.Reload(); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	incidents, err = snst.List()
+	if err != nil || len(incidents.Report) != 2 {
+		t.Errorf("Expected last good snapshot with 2 incidents, got %v", err)
+	}
+}
+
+func TestWriteKeepsExternalEdit(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	if _, err := snst.List(); err != nil {
+		t.Fatal(err)
+	}
+
+	// edited by someone else before Watch noticed it
+	changed := `{"Name":"ServiceNowQuery","Report":[{"number":"INC1","description":"edited outside",` +
+		`"state":"Open","priority":"Low","severity":"Low"}]}`
+	if err := ioutil.WriteFile(snst.File, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+
+	if _, err := snst.Create(servicenowStore.Incident{Description: "VM is hung",
+		State: "Open", Priority: "High", Severity: "Low"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	reopened, _ := servicenowStore.Init(snst.File)
+	if inc, err := reopened.Get("INC1"); err != nil || inc.Description != "edited outside" {
+		t.Errorf("Expected the external edit to be kept, got %+v %v", inc, err)
+	}
+	if _, err := reopened.Get("INC1234"); err != incidentsStore.ErrNotFound {
+		t.Errorf("Expected INC1234 removed by the external edit, got %v", err)
+	}
+
+	// a corrupt external edit fails the write instead of being overwritten
+	if err := ioutil.WriteFile(snst.File, []byte(`{"Name":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+	if _, err := snst.Create(servicenowStore.Incident{Description: "VM is hung",
+		State: "Open", Priority: "High", Severity: "Low"}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if data, _ := ioutil.ReadFile(snst.File); string(data) != `{"Name":` {
+		t.Errorf("Expected the file to be left alone, got %s", data)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	snst.Watch(10 * time.Millisecond)
+	defer snst.Close()
+
+	if _, err := snst.List(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	changed := `{"Name":"ServiceNowQuery","Report":[{"number":"INC2"}]}`
+	if err := ioutil.WriteFile(snst.File, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+
+	// wait for the watcher to pick up the change
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := snst.Get("INC2"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected INC2 to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRevision(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	rev, err := snst.Revision()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, _ := os.Stat(snst.File)
+	if rev.Number != 1 || !rev.Modified.Equal(info.ModTime()) {
+		t.Errorf("Expected revision 1 modified at %v, got %+v", info.ModTime(), rev)
+	}
+
+	// every write bumps the revision
+	snst.Delete("INC1234")
+	rev, _ = snst.Revision()
+	if rev.Number != 2 {
+		t.Errorf("Expected revision 2, got %+v", rev)
+	}
+
+	// failure case - file not found
+	snst.File = "file_not_found.json"
+	if _, err := snst.Revision(); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestClose(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	snst.Watch(time.Hour)
+	if err := snst.Close(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// reads keep working, writes are refused
+	if _, err := snst.Get("INC1234"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := snst.Delete("INC1234"); err != incidentsStore.ErrClosed {
+		t.Errorf("Expected %v, got %v", incidentsStore.ErrClosed, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	snst, cleanup := tempStore(t, "incidents_test.json")
+	defer cleanup()
+
+	snst.List()
+	stats := snst.Stats()
+	if stats.Reloads != 1 || stats.ParseErrors != 0 || stats.LastReload.IsZero() {
+		t.Errorf("Expected 1 load, got %+v", stats)
+	}
+
+	// corrupt file counts as parse error
+	ioutil.WriteFile(snst.File, []byte(`{"Name":`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+	snst.Reload()
+	if stats := snst.Stats(); stats.Reloads != 1 || stats.ParseErrors != 1 {
+		t.Errorf("Expected 1 parse error, got %+v", stats)
+	}
+
+	// the same bad version is reported again, but counted once
+	if err := snst.Check(); err == nil {
+		t.Errorf("Expected parse error, got nil")
+	}
+	if stats := snst.Stats(); stats.ParseErrors != 1 {
+		t.Errorf("Expected 1 parse error, got %+v", stats)
+	}
+
+	// a new bad version is counted again
+	ioutil.WriteFile(snst.File, []byte(`{"Name":1}`), 0644)
+	later = later.Add(time.Minute)
+	os.Chtimes(snst.File, later, later)
+	snst.Check()
+	if stats := snst.Stats(); stats.ParseErrors != 2 {
+		t.Errorf("Expected 2 parse errors, got %+v", stats)
+	}
+
+	// missing file is not a parse error
+	os.Remove(snst.File)
+	snst.Reload()
+	if stats := snst.Stats(); stats.ParseErrors != 2 {
+		t.Errorf("Expected 2 parse errors, got %+v", stats)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kinds of credentials in the keys file
+const (
+	KindAPIKey = "api_key" // cdk_<id>_<secret>, only the sha256 of the secret is kept
+	KindToken  = "token"   // <payload>.<signature>, signed with the signing secret of the keys file
+)
+
+// keys file managed by the keys command when none is configured
+const defaultKeysFile = "keys.json"
+
+// prefix of the api keys, makes them easy to spot in configs and leaks
+const apiKeyPrefix = "cdk_"
+
+// Returned by Authenticate for credentials that are malformed, unknown, revoked or expired
+var ErrInvalidCredentials = errors.New("invalid or revoked credentials")
+
+// keysFile is the content of the keys file
+type keysFile struct {
+	SigningSecret string   `json:"signing_secret"` // hex, signs the bearer tokens
+	Keys          []apiKey `json:"keys"`
+
+	secret []byte // decoded signing secret
+}
+
+// apiKey is an issued credential, revoked ones are kept so their ids are not reused
+type apiKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"` // identity of the holder, recorded in the incident history
+	Kind    string     `json:"kind"`
+	Hash    string     `json:"hash,omitempty"` // sha256 of the api key secret in hex
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// tokenPayload is the signed part of a bearer token
+type tokenPayload struct {
+	KeyID   string `json:"kid"`
+	Subject string `json:"sub"`
+	Expires int64  `json:"exp"`
+}
+
+/*
+Keyring validates credentials against the keys file
+The file is checked for changes on every request, so keys issued or revoked
+by the keys subcommand take effect without restarting the server
+*/
+type Keyring struct {
+	file    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	keys    *keysFile
+}
+
+// LoadKeyring reads the keys file, it fails if the file is missing or invalid
+func LoadKeyring(file string) (*Keyring, error) {
+	k := &Keyring{file: file}
+	if _, err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+/*
+reload reads the keys file again if it changed, on failure the last keys are kept
+It returns the current keys, nil if none could be loaded yet
+*/
+func (k *Keyring) reload() (*keysFile, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	info, err := os.Stat(k.file)
+	if err != nil {
+		return k.keys, err
+	}
+	if k.keys != nil && info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return k.keys, nil
+	}
+	keys, err := readKeysFile(k.file)
+	if err != nil {
+		return k.keys, err
+	}
+	k.keys, k.modTime, k.size = keys, info.ModTime(), info.Size()
+	return k.keys, nil
+}
+
+/*
+Authenticate returns the name of the holder of the api key or bearer token
+ErrInvalidCredentials is returned for anything that does not validate
+*/
+func (k *Keyring) Authenticate(credential string, now time.Time) (string, error) {
+	keys, err := k.reload()
+	if keys == nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(credential, apiKeyPrefix) {
+		return keys.authenticateAPIKey(credential, now)
+	}
+	return keys.authenticateToken(credential, now)
+}
+
+func (f *keysFile) authenticateAPIKey(credential string, now time.Time) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(credential, apiKeyPrefix), "_")
+	if len(parts) != 2 {
+		return "", ErrInvalidCredentials
+	}
+	key := f.find(parts[0])
+	if key == nil || key.Kind != KindAPIKey || !key.active(now) {
+		return "", ErrInvalidCredentials
+	}
+	sum := sha256.Sum256([]byte(parts[1]))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(key.Hash)) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return key.Name, nil
+}
+
+func (f *keysFile) authenticateToken(credential string, now time.Time) (string, error) {
+	parts := strings.Split(credential, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidCredentials
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, f.sign(parts[0])) {
+		return "", ErrInvalidCredentials
+	}
+	js, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+	var payload tokenPayload
+	if err := json.Unmarshal(js, &payload); err != nil {
+		return "", ErrInvalidCredentials
+	}
+	key := f.find(payload.KeyID)
+	if key == nil || key.Kind != KindToken || !key.active(now) ||
+		key.Name != payload.Subject || now.Unix() >= payload.Expires {
+		return "", ErrInvalidCredentials
+	}
+	return key.Name, nil
+}
+
+// sign returns the hmac-sha256 of payload with the signing secret
+func (f *keysFile) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// find returns the key with the given id
+func (f *keysFile) find(id string) *apiKey {
+	for i := range f.Keys {
+		if f.Keys[i].ID == id {
+			return &f.Keys[i]
+		}
+	}
+	return nil
+}
+
+// active reports whether the key is neither revoked nor expired
+func (key *apiKey) active(now time.Time) bool {
+	return key.Revoked == nil && (key.Expires == nil || now.Before(*key.Expires))
+}
+
+func readKeysFile(file string) (*keysFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys keysFile
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("keys file %s: %v", file, err)
+	}
+	if err := keys.decodeSecret(); err != nil {
+		return nil, fmt.Errorf("keys file %s: %v", file, err)
+	}
+	return &keys, nil
+}
+
+// decodeSecret decodes the signing secret, tokens must never be signed with a short or empty key
+func (f *keysFile) decodeSecret() error {
+	secret, err := hex.DecodeString(f.SigningSecret)
+	if err != nil {
+		return errors.New("signing secret must be hex")
+	}
+	if len(secret) < 32 {
+		return errors.New("signing secret must be at least 32 bytes")
+	}
+	f.secret = secret
+	return nil
+}
+
+/*
+writeKeysFile writes the keys atomically and readable by the owner only,
+the file holds the signing secret
+*/
+func writeKeysFile(file string, keys *keysFile) (err error) {
+	js, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err = tmp.Chmod(0600); err != nil {
+		return err
+	}
+	if _, err = tmp.Write(js); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/*
+issueKey adds a new credential for name to the keys file and returns it
+The file is created along with its signing secret if it does not exist.
+The credential is only returned here, the file does not allow to recover it.
+ttl is required for tokens and optional (0) for api keys
+*/
+func issueKey(file string, name string, kind string, ttl time.Duration, now time.Time) (string, *apiKey, error) {
+	if name == "" {
+		return "", nil, errors.New("name must not be empty")
+	}
+	if kind == KindToken && ttl <= 0 {
+		return "", nil, errors.New("tokens need a positive ttl")
+	}
+
+	keys, err := readKeysFile(file)
+	if os.IsNotExist(err) {
+		keys = &keysFile{}
+		if keys.SigningSecret, err = randomHex(32); err == nil {
+			err = keys.decodeSecret()
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := apiKey{Name: name, Kind: kind, Created: now.UTC()}
+	if key.ID, err = randomHex(8); err != nil {
+		return "", nil, err
+	}
+	if ttl > 0 {
+		expires := key.Created.Add(ttl)
+		key.Expires = &expires
+	}
+
+	var credential string
+	switch kind {
+	case KindAPIKey:
+		secret, err := randomHex(32)
+		if err != nil {
+			return "", nil, err
+		}
+		sum := sha256.Sum256([]byte(secret))
+		key.Hash = hex.EncodeToString(sum[:])
+		credential = apiKeyPrefix + key.ID + "_" + secret
+	case KindToken:
+		js, _ := json.Marshal(tokenPayload{KeyID: key.ID, Subject: name, Expires: key.Expires.Unix()})
+		payload := base64.RawURLEncoding.EncodeToString(js)
+		credential = payload + "." + base64.RawURLEncoding.EncodeToString(keys.sign(payload))
+	default:
+		return "", nil, fmt.Errorf("unknown kind %q", kind)
+	}
+
+	keys.Keys = append(keys.Keys, key)
+	if err := writeKeysFile(file, keys); err != nil {
+		return "", nil, err
+	}
+	return credential, &key, nil
+}
+
+// revokeKey marks the key with the given id as revoked
+func revokeKey(file string, id string, now time.Time) error {
+	keys, err := readKeysFile(file)
+	if err != nil {
+		return err
+	}
+	key := keys.find(id)
+	if key == nil {
+		return fmt.Errorf("no key with id %q", id)
+	}
+	if key.Revoked == nil {
+		revoked := now.UTC()
+		key.Revoked = &revoked
+	}
+	return writeKeysFile(file, keys)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempKeysFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "keys.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestKeyring(t *testing.T) {
+	file, cleanup := tempKeysFile(t)
+	defer cleanup()
+	now := time.Now()
+
+	apiKey, key, err := issueKey(file, "alice", KindAPIKey, 0, now)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	token, _, err := issueKey(file, "ci", KindToken, time.Hour, now)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected keys file readable by the owner only, got %v", info.Mode())
+	}
+	if data, _ := ioutil.ReadFile(file); bytes.Contains(data, []byte(strings.Split(apiKey, "_")[2])) {
+		t.Errorf("Expected only the hash of the api key in the keys file")
+	}
+
+	keyring, err := LoadKeyring(file)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	valid := map[string]string{apiKey: "alice", token: "ci"}
+	for credential, expected := range valid {
+		if name, err := keyring.Authenticate(credential, now); err != nil || name != expected {
+			t.Errorf("Expected %s, got %q %v", expected, name, err)
+		}
+	}
+
+	// tampered, expired and unknown credentials
+	payload := strings.Split(token, ".")[0]
+	invalid := []string{
+		apiKey + "0",
+		"cdk_0000000000000000_" + strings.Split(apiKey, "_")[2],
+		payload + "." + strings.Split(apiKey, "_")[2],
+		strings.Replace(token, payload, payload+"e30", 1),
+		"garbage",
+	}
+	for _, credential := range invalid {
+		if _, err := keyring.Authenticate(credential, now); err != ErrInvalidCredentials {
+			t.Errorf("%q: Expected ErrInvalidCredentials, got %v", credential, err)
+		}
+	}
+	if _, err := keyring.Authenticate(token, now.Add(2*time.Hour)); err != ErrInvalidCredentials {
+		t.Errorf("Expected expired token to fail, got %v", err)
+	}
+
+	// revocation is picked up without reloading the server
+	if err := revokeKey(file, key.ID, now); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	later := now.Add(time.Minute)
+	os.Chtimes(file, later, later)
+	if _, err := keyring.Authenticate(apiKey, now); err != ErrInvalidCredentials {
+		t.Errorf("Expected revoked key to fail, got %v", err)
+	}
+	if err := revokeKey(file, "nope", now); err == nil {
+		t.Errorf("Expected error for unknown id, got nil")
+	}
+}
+
+func TestReadKeysFileSecret(t *testing.T) {
+	file, cleanup := tempKeysFile(t)
+	defer cleanup()
+
+	secrets := []string{
+		strings.Repeat("z", 64), // not hex, would sign with an empty key
+		strings.Repeat("ab", 16),
+		"",
+	}
+	for _, secret := range secrets {
+		ioutil.WriteFile(file, []byte(`{"signing_secret":"`+secret+`","keys":[]}`), 0600)
+		if _, err := readKeysFile(file); err == nil {
+			t.Errorf("%q: Expected error, got nil", secret)
+		}
+		if _, err := LoadKeyring(file); err == nil {
+			t.Errorf("%q: Expected error, got nil", secret)
+		}
+	}
+
+	ioutil.WriteFile(file, []byte(`{"signing_secret":"`+strings.Repeat("ab", 32)+`","keys":[]}`), 0600)
+	keys, err := readKeysFile(file)
+	if err != nil || len(keys.secret) != 32 {
+		t.Errorf("Expected 32 byte secret, got %v %v", keys, err)
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	file, cleanup := tempKeysFile(t)
+	defer cleanup()
+	env := map[string]string{"CRAFTDEMO_AUTH_KEYS_FILE": file}
+	getenv := func(name string) string { return env[name] }
+
+	var stdout, stderr bytes.Buffer
+	if code := keysCommand([]string{"issue", "-name", "bob"}, getenv, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	credential := strings.TrimSpace(stdout.String())
+	if !strings.HasPrefix(credential, apiKeyPrefix) {
+		t.Errorf("Expected api key, got %q", credential)
+	}
+
+	stdout.Reset()
+	if code := keysCommand([]string{"list"}, getenv, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "bob") || strings.Contains(stdout.String(), credential) {
+		t.Errorf("Expected bob without the key in the list, got %s", stdout.String())
+	}
+
+	failures := [][]string{
+		{},
+		{"rotate"},
+		{"issue"},
+		{"issue", "-name", "bob", "-token"},
+		{"revoke", "-id", "nope"},
+	}
+	for _, args := range failures {
+		if code := keysCommand(args, getenv, &stdout, &stderr); code == 0 {
+			t.Errorf("%v: Expected failure, got 0", args)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const keysUsage = `usage: craftDemoServer keys <command> [flags]
+
+commands:
+  issue   -name NAME [-token -ttl DURATION]   issue an api key (or a signed token) for NAME
+  revoke  -id ID                              revoke the key with the given id
+  list                                        list the issued keys
+
+every command takes -keys-file, also ` + EnvPrefix + "AUTH_KEYS_FILE"
+
+/*
+keysCommand manages the keys file, it returns the exit code
+craftDemoServer keys issue -name alice
+craftDemoServer keys issue -name ci -token -ttl 24h
+craftDemoServer keys revoke -id 0123456789abcdef
+craftDemoServer keys list
+*/
+func keysCommand(args []string, getenv func(string) string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, keysUsage)
+		return 2
+	}
+
+	defaultFile := getenv(envName("auth-keys-file"))
+	if defaultFile == "" {
+		defaultFile = defaultKeysFile
+	}
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("keys-file", defaultFile, "keys file")
+	name := fs.String("name", "", "identity of the key holder")
+	token := fs.Bool("token", false, "issue a signed bearer token instead of an api key")
+	ttl := fs.Duration("ttl", 0, "lifetime of the key, required for tokens")
+	id := fs.String("id", "", "id of the key to revoke")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	now := time.Now()
+	switch args[0] {
+	case "issue":
+		kind := KindAPIKey
+		if *token {
+			kind = KindToken
+		}
+		credential, key, err := issueKey(*file, *name, kind, *ttl, now)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stderr, "issued %s %s for %s, it is not shown again\n", key.Kind, key.ID, key.Name)
+		fmt.Fprintln(stdout, credential)
+	case "revoke":
+		if err := revokeKey(*file, *id, now); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stderr, "revoked", *id)
+	case "list":
+		keys, err := readKeysFile(*file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tKIND\tCREATED\tEXPIRES\tREVOKED")
+		for _, key := range keys.Keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Kind,
+				key.Created.Format(time.RFC3339), formatTime(key.Expires), formatTime(key.Revoked))
+		}
+		tw.Flush()
+	default:
+		fmt.Fprintln(stderr, keysUsage)
+		return 2
+	}
+	return 0
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"model"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upper bounds of the request latency histogram in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies a request counter
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+// histogram counts observations per bucket, counts[i] is the number of
+// observations <= durationBuckets[i], the last one counts everything
+type histogram struct {
+	counts []uint64
+	sum    float64
+}
+
+/*
+httpMetrics collects the requests seen by RequestLogger
+Routes are the patterns of the mux, not the request paths, so the number of
+series does not grow with the number of incidents
+*/
+type httpMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*histogram
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// metrics of every request served by the process
+var requestMetrics = newHTTPMetrics()
+
+// observe records a served request
+func (m *httpMetrics) observe(route string, method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{route, method, code}]++
+
+	h, ok := m.durations[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[route] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(durationBuckets)]++
+	h.sum += seconds
+}
+
+// write prints the metrics in the prometheus text format, sorted so the output is stable
+func (m *httpMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	writeHeader(w, "craftdemo_http_requests_total", "counter", "Requests served, by route, method and status code.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "craftdemo_http_requests_total{route=%s,method=%s,code=\"%d\"} %d\n",
+			labelValue(key.route), labelValue(key.method), key.code, m.requests[key])
+	}
+
+	routes := make([]string, 0, len(m.durations))
+	for route := range m.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	writeHeader(w, "craftdemo_http_request_duration_seconds", "histogram", "Request latency, by route.")
+	for _, route := range routes {
+		h := m.durations[route]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "craftdemo_http_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n",
+				labelValue(route), formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "craftdemo_http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n",
+			labelValue(route), h.counts[len(durationBuckets)])
+		fmt.Fprintf(w, "craftdemo_http_request_duration_seconds_sum{route=%s} %s\n", labelValue(route), formatFloat(h.sum))
+		fmt.Fprintf(w, "craftdemo_http_request_duration_seconds_count{route=%s} %d\n",
+			labelValue(route), h.counts[len(durationBuckets)])
+	}
+}
+
+/*
+writeStoreMetrics prints the incident totals by state and priority
+Every allowed value is printed, even with a count of 0, so alerts do not have
+to deal with missing series. Reload stats are printed if the store keeps them
+If the incidents can not be listed, only the totals are left out and the error
+is returned, the stats are what tells why the store is failing
+*/
+func writeStoreMetrics(w io.Writer, store incidentsStore.IncidentStore) error {
+	incidents, err := store.List()
+	if err == nil {
+		byState := make(map[string]int)
+		byPriority := make(map[string]int)
+		for _, inc := range incidents.Report {
+			byState[string(inc.State)]++
+			byPriority[string(inc.Priority)]++
+		}
+		writeHeader(w, "craftdemo_incidents_by_state", "gauge", "Incidents in the store, by state.")
+		writeCounts(w, "craftdemo_incidents_by_state", "state", model.States, byState)
+		writeHeader(w, "craftdemo_incidents_by_priority", "gauge", "Incidents in the store, by priority.")
+		writeCounts(w, "craftdemo_incidents_by_priority", "priority", model.Priorities, byPriority)
+	}
+
+	if reporter, ok := store.(incidentsStore.StatsReporter); ok {
+		writeStoreStats(w, reporter.Stats())
+	}
+	return err
+}
+
+// writeStoreStats prints the load stats of the store file
+func writeStoreStats(w io.Writer, stats incidentsStore.Stats) {
+	writeHeader(w, "craftdemo_store_reloads_total", "counter", "Successful loads of the store file.")
+	fmt.Fprintf(w, "craftdemo_store_reloads_total %d\n", stats.Reloads)
+	writeHeader(w, "craftdemo_store_last_reload_timestamp_seconds", "gauge", "Unix time of the last successful load of the store file.")
+	lastReload := 0.0
+	if !stats.LastReload.IsZero() {
+		lastReload = float64(stats.LastReload.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(w, "craftdemo_store_last_reload_timestamp_seconds %s\n", formatFloat(lastReload))
+	writeHeader(w, "craftdemo_store_parse_errors_total", "counter", "Loads of the store file that failed to parse.")
+	fmt.Fprintf(w, "craftdemo_store_parse_errors_total %d\n", stats.ParseErrors)
+}
+
+// writeCounts prints one series per allowed value, followed by unexpected values in sorted order
+func writeCounts(w io.Writer, name string, label string, allowed []string, counts map[string]int) {
+	for _, v := range allowed {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, labelValue(v), counts[v])
+	}
+	var others []string
+	for v := range counts {
+		if !contains(allowed, v) {
+			others = append(others, v)
+		}
+	}
+	sort.Strings(others)
+	for _, v := range others {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, labelValue(v), counts[v])
+	}
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelValue quotes v, escaping backslashes, quotes and newlines
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metricsHandler serves the request and store metrics for prometheus
+func (s *IncidentServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+	// a failing store leaves out the incident totals, the rest is still worth scraping
+	var b strings.Builder
+	requestMetrics.write(&b)
+	if err := writeStoreMetrics(&b, s.store); err != nil {
+		log.WithField("request_id", w.Header().Get(RequestIDHeader)).Error("Store metrics failed: ", err)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// route returns the pattern of the mux serving r, "other" for unknown paths
+func route(handler http.Handler, r *http.Request) string {
+	mux, ok := handler.(interface {
+		Handler(r *http.Request) (http.Handler, string)
+	})
+	if !ok {
+		return "other"
+	}
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "other"
+}
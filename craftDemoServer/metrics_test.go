@@ -0,0 +1,134 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"craftDemoServer/incidentsStore/servicenowStore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	m := newHTTPMetrics()
+	m.observe("/api/v1/incidents/", "GET", 200, 20*time.Millisecond)
+	m.observe("/api/v1/incidents/", "GET", 404, 2*time.Second)
+	m.observe("/healthz", "GET", 200, time.Millisecond)
+
+	var b strings.Builder
+	m.write(&b)
+	out := b.String()
+
+	expected := []string{
+		"# TYPE craftdemo_http_requests_total counter\n",
+		`craftdemo_http_requests_total{route="/api/v1/incidents/",method="GET",code="200"} 1` + "\n",
+		`craftdemo_http_requests_total{route="/api/v1/incidents/",method="GET",code="404"} 1` + "\n",
+		"# TYPE craftdemo_http_request_duration_seconds histogram\n",
+		`craftdemo_http_request_duration_seconds_bucket{route="/api/v1/incidents/",le="0.01"} 0` + "\n",
+		`craftdemo_http_request_duration_seconds_bucket{route="/api/v1/incidents/",le="0.025"} 1` + "\n",
+		`craftdemo_http_request_duration_seconds_bucket{route="/api/v1/incidents/",le="2.5"} 2` + "\n",
+		`craftdemo_http_request_duration_seconds_bucket{route="/api/v1/incidents/",le="+Inf"} 2` + "\n",
+		`craftdemo_http_request_duration_seconds_sum{route="/api/v1/incidents/"} 2.02` + "\n",
+		`craftdemo_http_request_duration_seconds_count{route="/healthz"} 1` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, out)
+		}
+	}
+	// routes are sorted
+	if strings.Index(out, `route="/api/v1/incidents/"`) > strings.Index(out, `route="/healthz"`) {
+		t.Errorf("Expected sorted routes, got:\n%s", out)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if v := labelValue("a\"b\\c\nd"); v != `"a\"b\\c\nd"` {
+		t.Errorf("Expected escaped label, got %v", v)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Incident Report",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", State: "Open", Priority: "High"},
+			{Number: "INC2", State: "Open", Priority: "Low"},
+			{Number: "INC3", State: "Closed", Priority: "High"},
+		},
+	}}
+	handler := RequestLogger(NewIncidentServer(store).Handler(), AccessLogStructured, nil)
+
+	for _, path := range []string{"/api/v1/incidents/INC1", "/api/v1/incidents/INC9", "/nothing/here"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected text/plain, got %v", ct)
+	}
+	out := rr.Body.String()
+	expected := []string{
+		`craftdemo_http_requests_total{route="/api/v1/incidents/",method="GET",code="200"}`,
+		`craftdemo_http_requests_total{route="/api/v1/incidents/",method="GET",code="404"}`,
+		`craftdemo_http_requests_total{route="other",method="GET",code="404"}`,
+		`craftdemo_incidents_by_state{state="Open"} 2` + "\n",
+		`craftdemo_incidents_by_state{state="Closed"} 1` + "\n",
+		`craftdemo_incidents_by_state{state="New"} 0` + "\n",
+		`craftdemo_incidents_by_priority{priority="High"} 2` + "\n",
+		`craftdemo_incidents_by_priority{priority="Critical"} 0` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, out)
+		}
+	}
+	// the fake store keeps no reload stats
+	if strings.Contains(out, "craftdemo_store_reloads_total") {
+		t.Errorf("Expected no store stats, got:\n%s", out)
+	}
+}
+
+func TestMetricsHandlerStoreStats(t *testing.T) {
+	snst, _ := servicenowStore.Init("incidents.json")
+	handler := NewIncidentServer(snst).Handler()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	out := rr.Body.String()
+	for _, line := range []string{
+		"craftdemo_store_reloads_total 1\n",
+		"craftdemo_store_parse_errors_total 0\n",
+		"craftdemo_store_last_reload_timestamp_seconds ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, out)
+		}
+	}
+
+	// unreadable store, only the incident totals are left out
+	snst, _ = servicenowStore.Init("no_file.json")
+	rr = httptest.NewRecorder()
+	NewIncidentServer(snst).Handler().ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	out = rr.Body.String()
+	for _, line := range []string{"# TYPE craftdemo_http_requests_total counter\n", "craftdemo_store_reloads_total 0\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "craftdemo_incidents_by_state") {
+		t.Errorf("Expected no incident totals, got:\n%s", out)
+	}
+}
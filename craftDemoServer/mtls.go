@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+/*
+serverTLSConfig returns the tls config of the server
+With a client CA bundle configured, certificates presented by clients must be signed by one of its CAs.
+The certificate is optional in the handshake so probes and metrics work without one,
+requireClientCert enforces it on the api routes
+*/
+func serverTLSConfig(cfg *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", cfg.ClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+/*
+certIdentity maps a client certificate to the identity recorded in the logs and history
+The common name is used if set, otherwise the first DNS name, email address or URI of the SAN
+*/
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// peerIdentity returns the identity of the verified client certificate of r, "" without one
+func peerIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return certIdentity(r.TLS.VerifiedChains[0][0])
+}
+
+// requireClientCert answers 401 to requests without a verified client certificate
+func requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			audit(r, action(r), "", false, "client certificate required")
+			writeError(w, http.StatusUnauthorized, "unauthorized", "client certificate required")
+			return
+		}
+		next(w, r)
+	}
+}
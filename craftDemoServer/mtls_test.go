@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert creates a certificate signed by parent, a self signed CA without parent
+func testCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestCertIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://craftdemo/ci")
+	tests := []struct {
+		cert     x509.Certificate
+		expected string
+	}{
+		{x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, DNSNames: []string{"host"}}, "alice"},
+		{x509.Certificate{DNSNames: []string{"host"}, EmailAddresses: []string{"a@b"}}, "host"},
+		{x509.Certificate{EmailAddresses: []string{"a@b"}}, "a@b"},
+		{x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe://craftdemo/ci"},
+		{x509.Certificate{}, ""},
+	}
+	for _, test := range tests {
+		if id := certIdentity(&test.cert); id != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, id)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "clients"}}, nil, nil)
+	client, clientKey := testCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	other, otherKey := testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}, nil, nil)
+
+	bundle := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+
+	cfg := defaultConfig()
+	cfg.ClientCA = bundle
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	server := NewIncidentServer(&fakeStore{})
+	server.clientCerts = true
+	mux := http.NewServeMux()
+	mux.Handle("/", server.Handler())
+	mux.HandleFunc("/whoami", server.api(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requester(r)))
+	}))
+	ts := httptest.NewUnstartedServer(mux)
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(cert *x509.Certificate, key *ecdsa.PrivateKey, path string) (int, string, error) {
+		clientTLS := &tls.Config{InsecureSkipVerify: true}
+		if cert != nil {
+			clientTLS.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+		httpClient := http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		res, err := httpClient.Get(ts.URL + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body), err
+	}
+
+	if _, id, err := get(client, clientKey, "/whoami"); err != nil || id != "alice" {
+		t.Errorf("Expected alice, got %q %v", id, err)
+	}
+	// the api requires the certificate, the probes and metrics do not
+	for _, path := range []string{"/whoami", "/api/v1/list/incidents"} {
+		if status, _, err := get(nil, nil, path); err != nil || status != http.StatusUnauthorized {
+			t.Errorf("%s: Expected 401 without client certificate, got %v %v", path, status, err)
+		}
+	}
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		if status, _, err := get(nil, nil, path); err != nil || status != http.StatusOK {
+			t.Errorf("%s: Expected 200 without client certificate, got %v %v", path, status, err)
+		}
+	}
+	// a certificate of another CA is not accepted as client certificate
+	if status, id, err := get(other, otherKey, "/whoami"); err == nil && status == http.StatusOK {
+		t.Errorf("Expected failure with a certificate of another CA, got %q", id)
+	}
+
+	// configuration errors
+	cfg.ClientCA = filepath.Join(dir, "missing.crt")
+	if _, err := serverTLSConfig(cfg); err == nil {
+		t.Errorf("Expected error for missing bundle, got nil")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "empty.crt"), []byte("nothing"), 0644)
+	cfg.ClientCA = filepath.Join(dir, "empty.crt")
+	if _, err := serverTLSConfig(cfg); err == nil {
+		t.Errorf("Expected error for bundle without certificates, got nil")
+	}
+	cfg.ClientCA = ""
+	if tlsConfig, err := serverTLSConfig(cfg); err != nil || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected no client auth, got %v", err)
+	}
+}
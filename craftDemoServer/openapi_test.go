@@ -0,0 +1,297 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"fmt"
+	"model"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// spec is the parsed OpenAPI document, every node is a generic json value
+type spec map[string]interface{}
+
+func loadSpec(t *testing.T) spec {
+	var s spec
+	if err := json.Unmarshal(openAPISpec, &s); err != nil {
+		t.Fatalf("Expected valid json, got %v", err)
+	}
+	return s
+}
+
+// resolve follows $ref to the referenced node of the document
+func (s spec) resolve(node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var current interface{} = map[string]interface{}(s)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			current = current.(map[string]interface{})[part]
+		}
+		node = current.(map[string]interface{})
+	}
+}
+
+// validate checks value against the subset of json schema used by the document
+func (s spec) validate(schema map[string]interface{}, value interface{}, path string) error {
+	schema = s.resolve(schema)
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required %s", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, v := range object {
+			if property, ok := properties[name]; ok {
+				if err := s.validate(property.(map[string]interface{}), v, path+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unexpected property %s", path, name)
+				}
+			case map[string]interface{}:
+				if err := s.validate(additional, v, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+		for i, item := range array {
+			if err := s.validate(schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s: %q does not match %s", path, str, pattern)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, value)
+		}
+	}
+	return nil
+}
+
+// operation returns the operation of the spec serving method path
+func (s spec) operation(method string, path string) (map[string]interface{}, error) {
+	paths := s["paths"].(map[string]interface{})
+	for template, item := range paths {
+		pattern := "^" + regexp.MustCompile(`\\\{[a-z_]+\\\}`).ReplaceAllString(regexp.QuoteMeta(template), `[^/]+`) + "$"
+		// the literal summary route takes precedence over the {number} template
+		if template == "/api/v1/incidents/{number}" && path == "/api/v1/incidents/summary" {
+			continue
+		}
+		if regexp.MustCompile(pattern).MatchString(path) {
+			op, ok := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s %s is not documented", method, template)
+			}
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("path %s is not documented", path)
+}
+
+// conforms checks the recorded response against the documented responses of the operation
+func (s spec) conforms(method string, path string, rr *httptest.ResponseRecorder) error {
+	op, err := s.operation(method, path)
+	if err != nil {
+		return err
+	}
+	responses := op["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(rr.Code)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("status %d is not documented", rr.Code)
+	}
+	response = s.resolve(response)
+
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		if rr.Body.Len() > 0 {
+			return fmt.Errorf("expected no body, got %q", rr.Body.String())
+		}
+		return nil
+	}
+	contentType := strings.TrimSpace(strings.Split(rr.Header().Get("Content-Type"), ";")[0])
+	media, ok := content[contentType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("content type %q is not documented", contentType)
+	}
+	if contentType != "application/json" {
+		return nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		return fmt.Errorf("invalid json body: %v", err)
+	}
+	return s.validate(media["schema"].(map[string]interface{}), body, "body")
+}
+
+func TestOpenAPIConformance(t *testing.T) {
+	s := loadSpec(t)
+
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", AssignedTo: "Tom", Description: "Login is slow", State: "Open", Priority: "High", Severity: "Low"},
+			{Number: "INC2", AssignedTo: "Ann", Description: "VM is hung", State: "Blocked", Priority: "Low", Severity: "High"},
+		},
+	}}
+	server := NewIncidentServer(store)
+	server.auth = fakeAuth{"key": "alice"}
+	server.maxBodySize = 1 << 10
+	handler := server.Handler()
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		noAuth bool
+	}{
+		{"GET", "/api/v1/list/incidents", "", false},
+		{"GET", "/api/v1/list/incidents?state=open&sort=-priority&limit=1", "", false},
+		{"GET", "/api/v1/list/incidents?limit=0", "", false},
+		{"GET", "/api/v1/list/incidents", "", true},
+		{"GET", "/api/v1/incidents/INC1", "", false},
+		{"GET", "/api/v1/incidents/INC9", "", false},
+		{"GET", "/api/v1/incidents/summary?group_by=state,priority", "", false},
+		{"GET", "/api/v1/incidents/summary?group_by=color", "", false},
+		{"POST", "/api/v1/incidents", `{"description":"Disk full","priority":"Medium","severity":"Low"}`, false},
+		{"POST", "/api/v1/incidents", `{"description":"Disk full","priority":"Huge","severity":"Low"}`, false},
+		{"POST", "/api/v1/incidents", `{"description":"` + strings.Repeat("x", 2000) + `"}`, false},
+		{"PATCH", "/api/v1/incidents/INC1", `{"state":"In Progress"}`, false},
+		{"PATCH", "/api/v1/incidents/INC2", `{"state":"Closed"}`, false},
+		{"PUT", "/api/v1/incidents/INC2", `{"number":"INC2","description":"VM is hung","state":"Blocked","priority":"High","severity":"High"}`, false},
+		{"DELETE", "/api/v1/incidents/INC2", "", false},
+		{"GET", "/api/v1/openapi.json", "", true},
+		{"GET", "/healthz", "", true},
+		{"GET", "/readyz", "", true},
+		{"GET", "/version", "", true},
+		{"GET", "/metrics", "", true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if !test.noAuth {
+			req.Header.Set("Authorization", "Bearer key")
+		}
+		if test.method == "PUT" || test.method == "PATCH" || test.method == "DELETE" {
+			req.Header.Set("If-Match", "*")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if err := s.conforms(test.method, req.URL.Path, rr); err != nil {
+			t.Errorf("%s %s -> %d: %v", test.method, test.url, rr.Code, err)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := loadSpec(t)
+
+	// every route of the mux is documented
+	var documented []string
+	for path := range s["paths"].(map[string]interface{}) {
+		documented = append(documented, path)
+	}
+	sort.Strings(documented)
+	expected := []string{"/api/v1/incidents", "/api/v1/incidents/summary", "/api/v1/incidents/{number}",
+		"/api/v1/list/incidents", "/api/v1/openapi.json", "/healthz", "/metrics", "/readyz", "/version"}
+	if !reflect.DeepEqual(documented, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, documented)
+	}
+
+	// the enums follow the store
+	schemas := s["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	enums := map[string][]string{"State": model.States, "Level": model.Priorities}
+	for name, values := range enums {
+		var enum []string
+		for _, v := range schemas[name].(map[string]interface{})["enum"].([]interface{}) {
+			enum = append(enum, v.(string))
+		}
+		if !reflect.DeepEqual(enum, values) {
+			t.Errorf("Expected %s enum %v, got %v", name, values, enum)
+		}
+	}
+	if !reflect.DeepEqual(model.Priorities, model.Severities) {
+		t.Errorf("Expected priorities and severities to share the Level schema")
+	}
+
+	// every $ref points into the document
+	var check func(node interface{})
+	check = func(node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := n["$ref"].(string); ok {
+				func() {
+					defer func() {
+						if recover() != nil {
+							t.Errorf("Expected %s to exist", ref)
+						}
+					}()
+					if s.resolve(n) == nil {
+						t.Errorf("Expected %s to exist", ref)
+					}
+				}()
+			}
+			for _, v := range n {
+				check(v)
+			}
+		case []interface{}:
+			for _, v := range n {
+				check(v)
+			}
+		}
+	}
+	check(map[string]interface{}(s))
+
+	// served as is
+	rr := httptest.NewRecorder()
+	NewIncidentServer(&fakeStore{}).Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != string(openAPISpec) {
+		t.Errorf("Expected the document, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"fmt"
+	"model"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxPageLimit     = 1000 // maximum number of incidents in one page
+	DefaultPageLimit = 100  // incidents in one page when no limit is given
+)
+
+// query parameters used for pagination, not filtering
+var pageParams = map[string]bool{"limit": true, "offset": true, "sort": true}
+
+// incidentsPage is the list response, one page of the report along with
+// the total number of matching incidents and links to the next and previous pages
+type incidentsPage struct {
+	incidentsStore.Incidents
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+}
+
+// sortKey is one field of the sort parameter
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// page holds the parsed pagination parameters
+type page struct {
+	limit  int // 0 means no limit
+	offset int
+	sortBy []sortKey
+}
+
+// compare functions of the sortable fields
+// enumerated fields are ordered by their rank, e.g. Critical < High < Low
+var sortFields = map[string]func(a, b incidentsStore.Incident) int{
+	"number": func(a, b incidentsStore.Incident) int {
+		// INC999 sorts before INC1000
+		if len(a.Number) != len(b.Number) {
+			return len(a.Number) - len(b.Number)
+		}
+		return strings.Compare(a.Number, b.Number)
+	},
+	"assigned_to": func(a, b incidentsStore.Incident) int {
+		return strings.Compare(a.AssignedTo, b.AssignedTo)
+	},
+	"state": func(a, b incidentsStore.Incident) int {
+		return rank(model.States, string(a.State)) - rank(model.States, string(b.State))
+	},
+	"priority": func(a, b incidentsStore.Incident) int {
+		return rank(model.Priorities, string(a.Priority)) - rank(model.Priorities, string(b.Priority))
+	},
+	"severity": func(a, b incidentsStore.Incident) int {
+		return rank(model.Severities, string(a.Severity)) - rank(model.Severities, string(b.Severity))
+	},
+}
+
+/*
+parsePage extracts limit, offset and sort out of the query parameters
+?limit=10&offset=20&sort=priority,-number
+A leading '-' sorts the field in descending order
+Without limit a page holds DefaultPageLimit incidents, the next link leads to the rest
+*/
+func parsePage(query url.Values) (*page, error) {
+	p := page{limit: DefaultPageLimit}
+	var err error
+
+	if v := query.Get("limit"); v != "" {
+		p.limit, err = strconv.Atoi(v)
+		if err != nil || p.limit < 1 || p.limit > MaxPageLimit {
+			return nil, fmt.Errorf("invalid limit %q, must be between 1 and %d", v, MaxPageLimit)
+		}
+	}
+
+	if v := query.Get("offset"); v != "" {
+		p.offset, err = strconv.Atoi(v)
+		if err != nil || p.offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q, must be a non negative number", v)
+		}
+	}
+
+	if v := query.Get("sort"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			key := sortKey{field: strings.TrimSpace(field)}
+			if strings.HasPrefix(key.field, "-") {
+				key.field = key.field[1:]
+				key.desc = true
+			}
+			if _, ok := sortFields[key.field]; !ok {
+				return nil, fmt.Errorf("invalid sort field %q", key.field)
+			}
+			p.sortBy = append(p.sortBy, key)
+		}
+	}
+
+	return &p, nil
+}
+
+/*
+apply sorts the incidents and cuts the requested page out of them
+Sorting is stable, so equal incidents keep the report order
+u is the request url, it is used to build the next and prev links
+*/
+func (p *page) apply(incidents *incidentsStore.Incidents, u *url.URL) *incidentsPage {
+	report := incidents.Report
+
+	if len(p.sortBy) > 0 {
+		sort.SliceStable(report, func(i, j int) bool {
+			for _, key := range p.sortBy {
+				c := sortFields[key.field](report[i], report[j])
+				if key.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	result := &incidentsPage{Total: len(report)}
+	result.Name = incidents.Name
+
+	start, end := p.offset, len(report)
+	if start > end {
+		start = end
+	}
+	if p.limit > 0 && start+p.limit < end {
+		end = start + p.limit
+		result.Next = pageLink(u, p.limit, end)
+	}
+	if p.offset > 0 {
+		prev := 0
+		if p.limit > 0 && p.offset-p.limit > 0 {
+			prev = p.offset - p.limit
+		}
+		result.Prev = pageLink(u, p.limit, prev)
+	}
+	result.Report = report[start:end]
+
+	return result
+}
+
+// pageLink returns the request url pointing to the page at offset
+func pageLink(u *url.URL, limit int, offset int) string {
+	query := u.Query()
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	query.Set("offset", strconv.Itoa(offset))
+	link := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return link.String()
+}
+
+// rank returns the position of v in list, unknown values are ranked last
+func rank(list []string, v string) int {
+	for i, elem := range list {
+		if strings.EqualFold(elem, v) {
+			return i
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	query, _ := url.ParseQuery("limit=2&offset=4&sort=priority,-number")
+	p, err := parsePage(query)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if p.limit != 2 || p.offset != 4 || len(p.sortBy) != 2 {
+		t.Errorf("Expected limit 2 offset 4 and 2 sort keys, got %+v", p)
+	}
+	if p.sortBy[1].field != "number" || !p.sortBy[1].desc {
+		t.Errorf("Expected descending number, got %+v", p.sortBy[1])
+	}
+
+	// without limit the page has the default size
+	p, err = parsePage(url.Values{})
+	if err != nil || p.limit != DefaultPageLimit || p.offset != 0 {
+		t.Errorf("Expected default limit %d, got %+v %v", DefaultPageLimit, p, err)
+	}
+
+	// failure cases
+	for _, q := range []string{"limit=0", "limit=abc", "limit=1001", "offset=-1", "sort=color", "sort=priority,"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := parsePage(query); err == nil {
+			t.Errorf("%s: Expected error, got nil", q)
+		}
+	}
+}
+
+func TestPageApply(t *testing.T) {
+	incidents := func() *incidentsStore.Incidents {
+		return &incidentsStore.Incidents{Name: "Fake", Report: []incidentsStore.Incident{
+			{Number: "INC1000", Priority: "Low"},
+			{Number: "INC999", Priority: "Critical"},
+			{Number: "INC1001", Priority: "High"},
+			{Number: "INC1002", Priority: "Critical"},
+		}}
+	}
+	u, _ := url.Parse("/api/v1/list/incidents?state=Open")
+
+	numbers := func(res *incidentsPage) []string {
+		var n []string
+		for _, inc := range res.Report {
+			n = append(n, inc.Number)
+		}
+		return n
+	}
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// sort by priority then descending number
+	p := &page{sortBy: []sortKey{{"priority", false}, {"number", true}}}
+	res := p.apply(incidents(), u)
+	expected := []string{"INC1002", "INC999", "INC1001", "INC1000"}
+	if !equal(numbers(res), expected) {
+		t.Errorf("Expected %v, got %v", expected, numbers(res))
+	}
+	if res.Total != 4 || res.Next != "" || res.Prev != "" {
+		t.Errorf("Expected total 4 and no links, got %+v", res)
+	}
+
+	// number sorts numerically
+	p = &page{sortBy: []sortKey{{"number", false}}, limit: 2}
+	res = p.apply(incidents(), u)
+	expected = []string{"INC999", "INC1000"}
+	if !equal(numbers(res), expected) {
+		t.Errorf("Expected %v, got %v", expected, numbers(res))
+	}
+	if res.Next != "/api/v1/list/incidents?limit=2&offset=2&state=Open" || res.Prev != "" {
+		t.Errorf("Expected only next link, got %+v", res)
+	}
+
+	// last page
+	p = &page{limit: 3, offset: 3}
+	res = p.apply(incidents(), u)
+	expected = []string{"INC1002"}
+	if !equal(numbers(res), expected) {
+		t.Errorf("Expected %v, got %v", expected, numbers(res))
+	}
+	if res.Next != "" || res.Prev != "/api/v1/list/incidents?limit=3&offset=0&state=Open" {
+		t.Errorf("Expected only prev link, got %+v", res)
+	}
+
+	// offset past the end
+	p = &page{offset: 10}
+	res = p.apply(incidents(), u)
+	if len(res.Report) != 0 || res.Total != 4 {
+		t.Errorf("Expected empty page, got %+v", res)
+	}
+}
+
+func TestListHandlerPage(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", Priority: "Low"},
+			{Number: "INC2", Priority: "High"},
+		},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents?sort=priority&limit=1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var res incidentsPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Expected json body, got %v", err)
+	}
+	if len(res.Report) != 1 || res.Report[0].Number != "INC2" {
+		t.Errorf("Expected INC2, got %v", res.Report)
+	}
+	expected := "/api/v1/list/incidents?limit=1&offset=1&sort=priority"
+	if res.Total != 2 || res.Next != expected {
+		t.Errorf("Expected total 2 and next %s, got %+v", expected, res)
+	}
+	if total := rr.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected X-Total-Count 2, got %q", total)
+	}
+	if link := rr.Header().Get("Link"); link != "<"+expected+`>; rel="next"` {
+		t.Errorf("Expected next link header, got %q", link)
+	}
+
+	// a large store is paged even without limit
+	store.incidents.Report = nil
+	for i := 1; i <= DefaultPageLimit+50; i++ {
+		store.incidents.Report = append(store.incidents.Report, incidentsStore.Incident{Number: fmt.Sprintf("INC%d", i)})
+	}
+	req = httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	res = incidentsPage{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Expected json body, got %v", err)
+	}
+	expected = fmt.Sprintf("/api/v1/list/incidents?limit=%d&offset=%d", DefaultPageLimit, DefaultPageLimit)
+	if len(res.Report) != DefaultPageLimit || res.Total != DefaultPageLimit+50 || res.Next != expected {
+		t.Errorf("Expected %d of %d incidents and next %s, got %d %d %s",
+			DefaultPageLimit, DefaultPageLimit+50, expected, len(res.Report), res.Total, res.Next)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/list/incidents?limit=-1", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
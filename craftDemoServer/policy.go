@@ -0,0 +1,217 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roles of the api identities, each one can do what the previous ones can
+const (
+	RoleViewer    = "viewer"    // read incidents
+	RoleResponder = "responder" // also create incidents and modify the ones assigned to them or their groups
+	RoleAdmin     = "admin"     // also modify and delete any incident
+)
+
+// actions on the incidents, derived from the request method
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// policyFile is the content of the policy file
+type policyFile struct {
+	Roles       map[string]string   `yaml:"roles"`        // identity -> role
+	Groups      map[string][]string `yaml:"groups"`       // group -> member identities
+	DefaultRole string              `yaml:"default_role"` // role of identities not in roles, empty denies them
+}
+
+/*
+Policy decides which identities may do what on the incidents
+The file is checked for changes on every request, like the keys file
+*/
+type Policy struct {
+	file    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	policy  *policyFile
+}
+
+// LoadPolicy reads the policy file, it fails if the file is missing or invalid
+func LoadPolicy(file string) (*Policy, error) {
+	p := &Policy{file: file}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload reads the policy file again if it changed, on failure the last policy is kept
+func (p *Policy) reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.file)
+	if err != nil {
+		return err
+	}
+	if p.policy != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+	var policy policyFile
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return fmt.Errorf("policy file %s: %v", p.file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return fmt.Errorf("policy file %s: %v", p.file, err)
+	}
+	p.policy, p.modTime, p.size = &policy, info.ModTime(), info.Size()
+	return nil
+}
+
+func (f *policyFile) validate() error {
+	for identity, role := range f.Roles {
+		if !validRole(role) {
+			return fmt.Errorf("unknown role %q of %s", role, identity)
+		}
+	}
+	if f.DefaultRole != "" && !validRole(f.DefaultRole) {
+		return fmt.Errorf("unknown default role %q", f.DefaultRole)
+	}
+	return nil
+}
+
+func validRole(role string) bool {
+	return role == RoleViewer || role == RoleResponder || role == RoleAdmin
+}
+
+// current returns the latest policy that could be loaded
+func (p *Policy) current() *policyFile {
+	p.reload()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.policy
+}
+
+/*
+Allow decides whether identity may do action on inc, the stored incident for
+updates and deletes, nil otherwise. The reason explains denials
+*/
+func (p *Policy) Allow(identity string, action string, inc *incidentsStore.Incident) (bool, string) {
+	policy := p.current()
+
+	role, ok := policy.Roles[identity]
+	if !ok {
+		role = policy.DefaultRole
+	}
+	if role == "" {
+		return false, fmt.Sprintf("%q has no role", identity)
+	}
+
+	switch {
+	case role == RoleAdmin || action == ActionRead:
+		return true, ""
+	case role == RoleViewer:
+		return false, fmt.Sprintf("role %s can not %s incidents", role, action)
+	case action == ActionDelete:
+		return false, fmt.Sprintf("role %s can not %s incidents", role, action)
+	}
+
+	// responders create incidents and update the ones assigned to them or their groups
+	if inc == nil || policy.responsibleFor(identity, inc.AssignedTo) {
+		return true, ""
+	}
+	if inc.AssignedTo == "" {
+		return false, fmt.Sprintf("incident %s is not assigned, only admins can modify it", inc.Number)
+	}
+	return false, fmt.Sprintf("incident %s is assigned to %s, not %s or their groups", inc.Number, inc.AssignedTo, identity)
+}
+
+// responsibleFor reports whether assignee is identity or one of its groups
+// names are compared case insensitively, the assignee is typed by people
+func (f *policyFile) responsibleFor(identity string, assignee string) bool {
+	if assignee == "" {
+		return false
+	}
+	if strings.EqualFold(assignee, identity) {
+		return true
+	}
+	for group, members := range f.Groups {
+		if !strings.EqualFold(group, assignee) {
+			continue
+		}
+		for _, member := range members {
+			if strings.EqualFold(member, identity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// action returns what the request does to the incidents
+func action(r *http.Request) string {
+	switch r.Method {
+	case http.MethodPost:
+		return ActionCreate
+	case http.MethodPut, http.MethodPatch:
+		return ActionUpdate
+	case http.MethodDelete:
+		return ActionDelete
+	}
+	return ActionRead
+}
+
+// identity returns who made the request for authorization, "" if unknown
+func identity(r *http.Request) string {
+	if name := user(r); name != "" {
+		return name
+	}
+	return peerIdentity(r)
+}
+
+/*
+authorize only passes requests the policy allows to the handler
+Updates and deletes are checked against the stored incident, if it does not
+exist they are checked like creates and the handler answers the allowed ones.
+Denials and allowed writes go to the audit log
+*/
+func (s *IncidentServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		act := action(r)
+		number := ""
+		var inc *incidentsStore.Incident
+		if (act == ActionUpdate || act == ActionDelete) && strings.HasPrefix(r.URL.Path, "/api/v1/incidents/") {
+			number = strings.TrimPrefix(r.URL.Path, "/api/v1/incidents/")
+			stored, err := s.store.Get(number)
+			if err != nil && err != incidentsStore.ErrNotFound {
+				writeStoreError(w, err)
+				return
+			}
+			inc = stored
+		}
+
+		allowed, reason := s.policy.Allow(identity(r), act, inc)
+		if act != ActionRead || !allowed {
+			audit(r, act, number, allowed, reason)
+		}
+		if !allowed {
+			writeError(w, http.StatusForbidden, "forbidden", reason)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+roles:
+  alice: admin
+  bob: responder
+  vic: viewer
+groups:
+  network: [bob]
+  dba: [Bob]
+`
+
+func writePolicy(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "policy.yaml")
+	ioutil.WriteFile(file, []byte(content), 0644)
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestPolicyAllow(t *testing.T) {
+	file, cleanup := writePolicy(t, testPolicy)
+	defer cleanup()
+	policy, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	own := &incidentsStore.Incident{Number: "INC1", AssignedTo: "Bob"}
+	group := &incidentsStore.Incident{Number: "INC2", AssignedTo: "network"}
+	other := &incidentsStore.Incident{Number: "INC3", AssignedTo: "dave"}
+	unassigned := &incidentsStore.Incident{Number: "INC4"}
+
+	tests := []struct {
+		identity string
+		action   string
+		inc      *incidentsStore.Incident
+		allowed  bool
+	}{
+		{"vic", ActionRead, nil, true},
+		{"vic", ActionCreate, nil, false},
+		{"vic", ActionUpdate, own, false},
+		{"bob", ActionCreate, nil, true},
+		{"bob", ActionUpdate, own, true},
+		{"bob", ActionUpdate, group, true},
+		{"bob", ActionUpdate, unassigned, false},
+		{"bob", ActionUpdate, &incidentsStore.Incident{Number: "INC6", AssignedTo: "dba"}, true},
+		{"bob", ActionUpdate, &incidentsStore.Incident{Number: "INC5", AssignedTo: "Network"}, true},
+		{"alice", ActionUpdate, unassigned, true},
+		{"bob", ActionUpdate, other, false},
+		{"bob", ActionDelete, own, false},
+		{"alice", ActionUpdate, other, true},
+		{"alice", ActionDelete, other, true},
+		// no default role
+		{"mallory", ActionRead, nil, false},
+		{"", ActionRead, nil, false},
+	}
+	for _, test := range tests {
+		allowed, reason := policy.Allow(test.identity, test.action, test.inc)
+		if allowed != test.allowed {
+			t.Errorf("%s %s %+v: Expected %v, got %v", test.identity, test.action, test.inc, test.allowed, allowed)
+		}
+		if !allowed && reason == "" {
+			t.Errorf("%s %s: Expected reason of the denial", test.identity, test.action)
+		}
+	}
+
+	// changes are picked up, invalid ones keep the last policy
+	ioutil.WriteFile(file, []byte(testPolicy+"default_role: viewer\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(file, later, later)
+	if allowed, _ := policy.Allow("mallory", ActionRead, nil); !allowed {
+		t.Errorf("Expected default role to allow reads")
+	}
+	ioutil.WriteFile(file, []byte("roles: {eve: root}\n"), 0644)
+	later = later.Add(time.Minute)
+	os.Chtimes(file, later, later)
+	if allowed, _ := policy.Allow("alice", ActionDelete, other); !allowed {
+		t.Errorf("Expected the last good policy to be kept")
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, content := range []string{"roles: {eve: root}\n", "default_role: owner\n", "rolez: {}\n"} {
+		file, cleanup := writePolicy(t, content)
+		if _, err := LoadPolicy(file); err == nil {
+			t.Errorf("%q: Expected error, got nil", content)
+		}
+		cleanup()
+	}
+	if _, err := LoadPolicy("no_policy.yaml"); err == nil {
+		t.Errorf("Expected error for missing file, got nil")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	file, cleanup := writePolicy(t, testPolicy)
+	defer cleanup()
+	policy, _ := LoadPolicy(file)
+
+	var trail bytes.Buffer
+	auditLog.Out = &trail
+	defer func() { auditLog.Out = os.Stderr }()
+
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", Description: "mine", State: "Open", Priority: "High", Severity: "High", AssignedTo: "bob"},
+			{Number: "INC2", Description: "theirs", State: "Open", Priority: "High", Severity: "High", AssignedTo: "dave"},
+		},
+	}}
+	server := NewIncidentServer(store)
+	server.auth = fakeAuth{"alice-key": "alice", "bob-key": "bob", "vic-key": "vic"}
+	server.policy = policy
+	handler := server.Handler()
+
+	tests := []struct {
+		key      string
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{"vic-key", "GET", "/api/v1/incidents/INC1", ``, http.StatusOK},
+		{"vic-key", "GET", "/api/v1/list/incidents", ``, http.StatusOK},
+		{"vic-key", "PATCH", "/api/v1/incidents/INC1", `{"priority":"Low"}`, http.StatusForbidden},
+		{"bob-key", "PATCH", "/api/v1/incidents/INC1", `{"priority":"Low"}`, http.StatusOK},
+		{"bob-key", "PATCH", "/api/v1/incidents/INC2", `{"priority":"Low"}`, http.StatusForbidden},
+		{"bob-key", "PATCH", "/api/v1/incidents/INC9", `{"priority":"Low"}`, http.StatusNotFound},
+		{"vic-key", "DELETE", "/api/v1/incidents/INC9", ``, http.StatusForbidden},
+		{"bob-key", "DELETE", "/api/v1/incidents/INC1", ``, http.StatusForbidden},
+		{"bob-key", "POST", "/api/v1/incidents", `{"description":"new","priority":"Low","severity":"Low"}`, http.StatusCreated},
+		{"alice-key", "PATCH", "/api/v1/incidents/INC2", `{"priority":"Low"}`, http.StatusOK},
+		{"alice-key", "DELETE", "/api/v1/incidents/INC2", ``, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("Authorization", "Bearer "+test.key)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("%s %s %s: handler returned wrong status code: got %v want %v",
+				test.key, test.method, test.path, status, test.expected)
+		}
+		if rr.Code == http.StatusForbidden && !strings.Contains(rr.Body.String(), `"code":"forbidden"`) {
+			t.Errorf("Expected forbidden error with reason, got %s", rr.Body.String())
+		}
+	}
+
+	// denials and allowed writes are audited, reads are not
+	var denied, allowed int
+	for _, line := range strings.Split(strings.TrimSpace(trail.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected json audit entry, got %q", line)
+		}
+		switch entry["outcome"] {
+		case "denied":
+			denied++
+			if entry["reason"] == "" || entry["identity"] == "" {
+				t.Errorf("Expected identity and reason, got %v", entry)
+			}
+		case "allowed":
+			allowed++
+		}
+	}
+	if denied != 4 || allowed != 5 {
+		t.Errorf("Expected 4 denied and 5 allowed actions, got %d %d:\n%s", denied, allowed, trail.String())
+	}
+
+	// failures of the store are not mistaken for missing incidents
+	store.err = incidentsStore.ErrClosed
+	req := httptest.NewRequest("DELETE", "/api/v1/incidents/INC1", nil)
+	req.Header.Set("Authorization", "Bearer vic-key")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket holds the tokens of one client
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last refilled
+}
+
+/*
+rateLimiter is a token bucket per client
+Every client may do burst requests at once and rate requests per second on average.
+Buckets that have been idle long enough to be full again are dropped
+*/
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func newRateLimiter(rate float64, burst int64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token of the client, if there is none it returns how long to wait for the next one
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b := l.refill(client, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, l.waitFor(b)
+}
+
+// wait returns how long the client has to wait for its next token without taking one, 0 if it has one
+func (l *rateLimiter) wait(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return l.waitFor(b)
+}
+
+// refill returns the bucket of the client with the tokens earned since its last use, mu must be held
+func (l *rateLimiter) refill(client string, now time.Time) *bucket {
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// waitFor returns how long b needs to earn a whole token
+func (l *rateLimiter) waitFor(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// prune drops the full buckets once a minute, mu must be held
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// client returns the key of the bucket of r, the api identity or the remote ip
+func client(r *http.Request) string {
+	if name := identity(r); name != "" {
+		return "identity:" + name
+	}
+	return remoteClient(r)
+}
+
+// remoteClient returns the key of the bucket of the remote ip of r
+func remoteClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimit answers 429 with Retry-After to clients that ran out of tokens
+func (s *IncidentServer) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := s.limiter.allow(client(r), time.Now()); !ok {
+			writeRateLimited(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+/*
+limitFailures rate limits the requests failing authentication by remote ip, it sits in front of auth
+Once an ip ran out of tokens all of its requests get 429, before their credentials are checked and audited,
+until it earned a token again.
+Authenticated requests take no token here, they are limited by identity in rateLimit
+*/
+func (s *IncidentServer) limitFailures(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := remoteClient(r)
+		if wait := s.limiter.wait(key, time.Now()); wait > 0 {
+			writeRateLimited(w, wait)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == http.StatusUnauthorized {
+			s.limiter.allow(key, time.Now())
+		}
+	}
+}
+
+// writeRateLimited answers 429 with the seconds to wait in Retry-After
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	retry := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeErrorDetails(w, http.StatusTooManyRequests, "rate_limited",
+		fmt.Sprintf("too many requests, retry in %v", wait.Round(time.Millisecond)),
+		map[string]interface{}{"retry_after": retry})
+}
+
+// limitBody caps the request body of writes at s.maxBodySize bytes, larger bodies get 413
+func (s *IncidentServer) limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+			if r.ContentLength > s.maxBodySize {
+				writeErrorDetails(w, http.StatusRequestEntityTooLarge, "body_too_large",
+					fmt.Sprintf("request body must not exceed %d bytes", s.maxBodySize),
+					map[string]interface{}{"limit": s.maxBodySize})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+		}
+		next(w, r)
+	}
+}
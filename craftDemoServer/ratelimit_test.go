@@ -0,0 +1,137 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms, got %v %v", ok, wait)
+	}
+	// other clients have their own bucket
+	if ok, _ := l.allow("b", now); !ok {
+		t.Errorf("Expected other client to be allowed")
+	}
+	// tokens are refilled at rate
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("Expected refilled token to be allowed")
+	}
+
+	// idle buckets are dropped
+	l.allow("a", now.Add(2*time.Minute))
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected idle buckets to be pruned, got %d", len(l.buckets))
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	server := NewIncidentServer(&fakeStore{incidents: incidentsStore.Incidents{Name: "Fake"}})
+	server.auth = fakeAuth{"alice-key": "alice", "bob-key": "bob"}
+	server.limiter = newRateLimiter(1, 2)
+	handler := server.Handler()
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	for i := 0; i < 2; i++ {
+		if rr := get("alice-key"); rr.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %v", rr.Code)
+		}
+	}
+	rr := get("alice-key")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1, got %v %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	// keyed by identity, not by address
+	if rr := get("bob-key"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another identity, got %v", rr.Code)
+	}
+	// requests failing auth are limited by ip before their credentials are checked
+	failures := 0
+	for i := 0; i < 4; i++ {
+		if rr := get("wrong-key"); rr.Code == http.StatusTooManyRequests {
+			failures++
+		} else if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 or 429, got %v", rr.Code)
+		}
+	}
+	if failures != 2 {
+		t.Errorf("Expected the last 2 of 4 failing requests to be limited, got %d", failures)
+	}
+	// other ips are not affected
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+	req.Header.Set("Authorization", "Bearer bob-key")
+	req.RemoteAddr = "10.0.0.2:1234"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another ip, got %v", rr.Code)
+	}
+	// probes are not limited
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 for probes, got %v", rr.Code)
+		}
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	if key := client(req); key != "ip:10.0.0.1" {
+		t.Errorf("Expected ip key, got %v", key)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	store := &fakeStore{}
+	server := NewIncidentServer(store)
+	server.maxBodySize = 64
+	handler := server.Handler()
+
+	small := `{"description":"VM is hung","priority":"Low","severity":"Low"}`
+	large := `{"description":"` + strings.Repeat("x", 100) + `","priority":"Low","severity":"Low"}`
+
+	tests := []struct {
+		body     string
+		chunked  bool
+		expected int
+	}{
+		{small, false, http.StatusCreated},
+		{large, false, http.StatusRequestEntityTooLarge},
+		// without Content-Length the body is cut while decoding
+		{large, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/incidents", strings.NewReader(test.body))
+		if test.chunked {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.expected {
+			t.Errorf("%d bytes: handler returned wrong status code: got %v want %v", len(test.body), rr.Code, test.expected)
+		}
+	}
+}
@@ -1,72 +1,243 @@
 package main
 
 import (
+	"context"
+	"craftDemoServer/incidentsStore"
 	"craftDemoServer/incidentsStore/servicenowStore"
+	"flag"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-var snst *servicenowStore.ServicenowStore
+// IncidentServer serves the incidents api from the given store
+// With auth set, the api routes require a valid api key or token, the probes and metrics do not
+// With clientCerts set, the api routes also require a verified client certificate
+// With policy set, the api routes are limited to what the role of the identity allows
+// With limiter set, every client is limited to its rate, maxBodySize limits the body of writes
+type IncidentServer struct {
+	store       incidentsStore.IncidentStore
+	auth        Authenticator
+	policy      *Policy
+	limiter     *rateLimiter
+	maxBodySize int64
+	clientCerts bool
+}
 
-func main() {
+// NewIncidentServer creates a server backed by store
+func NewIncidentServer(store incidentsStore.IncidentStore) *IncidentServer {
+	return &IncidentServer{store: store}
+}
 
+// Handler returns the api routes of the server
+func (s *IncidentServer) Handler() http.Handler {
 	mux := http.NewServeMux()
+	// Add the handler for /api/v1/list/incidents api call
+	mux.HandleFunc("/api/v1/list/incidents", s.api(s.listHandler))
+	// Add the handlers for /api/v1/incidents and /api/v1/incidents/{number} api calls
+	mux.HandleFunc("/api/v1/incidents", s.api(s.incidentsHandler))
+	mux.HandleFunc("/api/v1/incidents/", s.api(s.incidentHandler))
+	// Add the handler for /api/v1/incidents/summary api call
+	mux.HandleFunc("/api/v1/incidents/summary", s.api(s.summaryHandler))
+	// Add the OpenAPI document of the api
+	mux.HandleFunc("/api/v1/openapi.json", openAPIHandler)
+	// Unknown api paths get the json error envelope instead of the text 404 of the mux
+	mux.HandleFunc("/api/", notFoundHandler)
+	// Add the probes and build info
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.HandleFunc("/version", versionHandler)
+	// Add the prometheus metrics
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	return mux
+}
+
+// api wraps the handler of an api route with the middlewares of the api
+// in order: rate limit of failed auth, client certificate, auth, rate limit, policy, body size limit
+func (s *IncidentServer) api(handler http.HandlerFunc) http.HandlerFunc {
+	if s.maxBodySize > 0 {
+		handler = s.limitBody(handler)
+	}
+	if s.policy != nil {
+		handler = s.authorize(handler)
+	}
+	if s.limiter != nil {
+		handler = s.rateLimit(handler)
+	}
+	if s.auth != nil {
+		handler = requireAuth(s.auth, handler)
+	}
+	if s.clientCerts {
+		handler = requireClientCert(handler)
+	}
+	if s.limiter != nil && (s.auth != nil || s.clientCerts) {
+		handler = s.limitFailures(handler)
+	}
+	return handler
+}
 
+// newStore creates the incident store of the configured backend
+func newStore(cfg *Config) (incidentsStore.IncidentStore, error) {
+	switch cfg.Store {
+	case "servicenow":
+		snst, err := servicenowStore.Init(cfg.DataFile)
+		if err != nil {
+			return nil, err
+		}
+		snst.Watch(cfg.ReloadInterval)
+		return snst, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Store)
+	}
+}
+
+func main() {
 	Formatter := new(log.TextFormatter)
 	Formatter.TimestampFormat = "02-01-2006 15:04:05"
 	Formatter.FullTimestamp = true
 	log.SetFormatter(Formatter)
 
-	// initialize incident store
-	// TODO: another level of abstraction?
-	snst, _ = servicenowStore.Init("")
-	log.Info("Server starting...")
-	log.Info("Initializing serviceNow store")
-
-	// Add the handler for /api/v1/list/incidents api call
-	mux.HandleFunc("/api/v1/list/incidents", httpHandler)
-	//http.ListenAndServe(":3000", nil)
+	// craftDemoServer keys ... manages the api keys
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(keysCommand(os.Args[2:], os.Getenv, os.Stdout, os.Stderr))
+	}
+	// craftDemoServer gen-cert ... creates a CA and a server certificate
+	if len(os.Args) > 1 && os.Args[1] == "gen-cert" {
+		os.Exit(genCertCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
-	// enable SSL
-	err := http.ListenAndServeTLS(":443", "server.crt", "server.key", RequestLogger(mux))
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatal(err)
+	}
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+	if cfg.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
 	}
 
-}
-
-func RequestLogger(targetMux http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+	log.Info("Server starting...")
+	log.Info("Initializing ", cfg.Store, " store")
 
-		targetMux.ServeHTTP(w, r)
+	// initialize incident store
+	store, err := newStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewIncidentServer(store)
+	server.maxBodySize = cfg.MaxBodySize
+	if cfg.RateLimit > 0 {
+		server.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.AuthKeysFile != "" {
+		keyring, err := LoadKeyring(cfg.AuthKeysFile)
+		if err != nil {
+			log.Fatal("Loading the keys failed, issue one with `craftDemoServer keys issue -name NAME`: ", err)
+		}
+		server.auth = keyring
+	} else {
+		log.Warn("No keys file configured, the api is open to everyone")
+	}
+	if cfg.PolicyFile != "" {
+		policy, err := LoadPolicy(cfg.PolicyFile)
+		if err != nil {
+			log.Fatal("Loading the policy failed: ", err)
+		}
+		server.policy = policy
+	}
+	if cfg.AuditLog != "" {
+		auditFile, err := os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatal("Opening the audit log failed: ", err)
+		}
+		defer auditFile.Close()
+		auditLog.Out = auditFile
+	}
 
-		// log request by who(IP address)
-		requesterIP := r.RemoteAddr
+	if cfg.TLSAutoGenerate {
+		pin, err := ensureCerts(cfg, time.Now())
+		if err != nil {
+			log.Fatal("Generating the TLS certificate failed: ", err)
+		}
+		if pin != "" {
+			log.Info("Generated ", cfg.TLSCert, ", clients can trust it with -pin ", pin)
+		}
+	}
 
-		log.Printf(
-			"%s\t\t%s\t\t%s\t\t%v",
-			r.Method,
-			r.RequestURI,
-			requesterIP,
-			time.Since(start),
-		)
-	})
-}
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Fatal("Loading the client CA failed: ", err)
+	}
+	if tlsConfig.ClientCAs != nil {
+		log.Info("Client certificates are verified against ", cfg.ClientCA, " and required by the api")
+		server.clientCerts = true
+	}
 
-func httpHandler(w http.ResponseWriter, r *http.Request) {
+	handler := server.Handler()
+	if origins := splitList(cfg.CORSOrigins); len(origins) > 0 {
+		handler = CORS(handler, origins)
+	}
 
-	// get the response from serviceNow obj
+	httpServer := &http.Server{
+		Addr:         cfg.Addr,
+		TLSConfig:    tlsConfig,
+		Handler:      RequestLogger(handler, cfg.AccessLogFormat, os.Stdout),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
 
-	js, err := snst.GetList()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
+	// enable SSL
+	log.Info("Listening on ", cfg.Addr)
+	err = serve(httpServer, func() error {
+		return httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	}, store, cfg.ShutdownTimeout, signals)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Fatal("ListenAndServe: ", err)
+	}
+}
+
+/*
+serve runs listen until it fails or a signal is received
+On a signal, the server stops accepting connections and in-flight requests get
+up to timeout to finish. The store is closed afterwards, which waits for pending writes
+*/
+func serve(httpServer *http.Server, listen func() error, store incidentsStore.IncidentStore,
+	timeout time.Duration, signals <-chan os.Signal) error {
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- listen()
+	}()
+
+	select {
+	case err := <-errc:
+		store.Close()
+		return err
+	case sig := <-signals:
+		log.Info("Received ", sig, ", shutting down")
 	}
 
-	// Set the content-type header to json
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(*js)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	shutdownErr := httpServer.Shutdown(ctx)
+	if shutdownErr != nil {
+		log.Warn("Requests still in flight after ", timeout, ": ", shutdownErr)
+	}
+	if err := store.Close(); err != nil {
+		log.Warn("Closing the store failed: ", err)
+	}
+	log.Info("Server stopped")
+	return shutdownErr
 }
@@ -1,22 +1,135 @@
 package main
 
 import (
+	"craftDemoServer/incidentsStore"
 	"craftDemoServer/incidentsStore/servicenowStore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
+// fakeStore serves a fixed report, or err if set
+type fakeStore struct {
+	incidents incidentsStore.Incidents
+	revision  incidentsStore.Revision
+	err       error
+	closed    bool
+}
+
+func (f *fakeStore) List() (*incidentsStore.Incidents, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &f.incidents, nil
+}
+
+func (f *fakeStore) Get(number string) (*incidentsStore.Incident, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	for i := range f.incidents.Report {
+		if f.incidents.Report[i].Number == number {
+			return &f.incidents.Report[i], nil
+		}
+	}
+	return nil, incidentsStore.ErrNotFound
+}
+
+func (f *fakeStore) Query(match incidentsStore.Predicate) (*incidentsStore.Incidents, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := incidentsStore.Incidents{Name: f.incidents.Name, Report: []incidentsStore.Incident{}}
+	for _, inc := range f.incidents.Report {
+		if match == nil || match(inc) {
+			result.Report = append(result.Report, inc)
+		}
+	}
+	return &result, nil
+}
+
+func (f *fakeStore) Create(inc incidentsStore.Incident) (*incidentsStore.Incident, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if err := inc.Validate(); err != nil {
+		return nil, err
+	}
+	inc.Number = fmt.Sprintf("INC%d", len(f.incidents.Report)+1)
+	inc.Revision = 1
+	f.incidents.Report = append(f.incidents.Report, inc)
+	return &inc, nil
+}
+
+func (f *fakeStore) Update(inc incidentsStore.Incident) (*incidentsStore.Incident, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if err := inc.Validate(); err != nil {
+		return nil, err
+	}
+	for i := range f.incidents.Report {
+		if f.incidents.Report[i].Number == inc.Number {
+			if f.incidents.Report[i].Revision != inc.Revision {
+				return nil, incidentsStore.ErrConflict
+			}
+			inc.Revision++
+			f.incidents.Report[i] = inc
+			return &inc, nil
+		}
+	}
+	return nil, incidentsStore.ErrNotFound
+}
+
+func (f *fakeStore) Delete(number string) error {
+	if f.err != nil {
+		return f.err
+	}
+	for i := range f.incidents.Report {
+		if f.incidents.Report[i].Number == number {
+			f.incidents.Report = append(f.incidents.Report[:i], f.incidents.Report[i+1:]...)
+			return nil
+		}
+	}
+	return incidentsStore.ErrNotFound
+}
+
+func (f *fakeStore) Revision() (*incidentsStore.Revision, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &f.revision, nil
+}
+
+func (f *fakeStore) Check() error {
+	return f.err
+}
+
+func (f *fakeStore) Close() error {
+	f.closed = true
+	return nil
+}
+
 func TestHttpHandler(t *testing.T) {
 	//
-	snst, _ = servicenowStore.Init("no_file.json")
+	snst, _ := servicenowStore.Init("no_file.json")
+	server := NewIncidentServer(snst)
 	req, err := http.NewRequest("GET", "/api/v1/list/incidents", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(httpHandler)
+	handler := server.Handler()
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
@@ -27,8 +140,17 @@ func TestHttpHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want non 200",
 			status)
 	}
+	// the failure is a json error that does not leak the file path
+	var apiErr apiError
+	if err := json.Unmarshal(rr.Body.Bytes(), &apiErr); err != nil || apiErr.Code == "" {
+		t.Errorf("Expected json error, got %s", rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "no_file.json") {
+		t.Errorf("Expected no file path in the error, got %s", rr.Body.String())
+	}
 
 	snst, _ = servicenowStore.Init("")
+	handler = NewIncidentServer(snst).Handler()
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
@@ -46,7 +168,211 @@ func TestHttpHandler(t *testing.T) {
 	}*/
 }
 
-/*func TestIncidentServer_Run(t *testing.T) {
-	incidentServer := IncidentServer{}
-	incidentServer.snStore, _ = servicenowStore.Init("")
-}*/
+func TestHttpHandlerFakeStore(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name:   "Fake",
+		Report: []incidentsStore.Incident{{Number: "INC1"}},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/list/incidents", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"Name":"Fake","Report":[{"number":"INC1","assigned_to":"","description":"","state":"","priority":"","severity":""}],"total":1}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// store failure
+	store.err = errors.New("store down")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":"internal_error","message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{{Number: "INC1", Description: "Login is not working",
+			State: "Closed", Priority: "High", Severity: "High"}},
+	}}
+	handler := RequestLogger(NewIncidentServer(store).Handler(), AccessLogStructured, ioutil.Discard)
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+		code     string
+		details  map[string]interface{}
+	}{
+		{"GET", "/api/v1/list/incidents?color=red", "", http.StatusBadRequest, "invalid_query", nil},
+		{"GET", "/api/v1/incidents/INC9", "", http.StatusNotFound, "not_found", nil},
+		{"GET", "/api/v1/foo", "", http.StatusNotFound, "not_found", nil},
+		{"POST", "/api/v1/incidents", `{"description":"new","priority":"Low","severity":"Low"} garbage`,
+			http.StatusBadRequest, "invalid_body", nil},
+		{"PUT", "/api/v1/incidents/INC1", `{"description":"a","state":"Closed","priority":"Low","severity":"Low"}{}`,
+			http.StatusBadRequest, "invalid_body", nil},
+		{"PATCH", "/api/v1/incidents/INC1", `{"severity":"Huge"}`, http.StatusBadRequest, "invalid_incident",
+			map[string]interface{}{"field": "severity"}},
+		{"PATCH", "/api/v1/incidents/INC1", `{"state":"In Progress"}`, http.StatusConflict, "invalid_transition",
+			map[string]interface{}{"from": "Closed", "to": "In Progress", "allowed": []interface{}{"Open"}}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("X-Request-ID", "req-42")
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expected {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v",
+				test.method, test.path, rr.Code, test.expected)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: Expected application/json, got %s", test.method, test.path, ct)
+		}
+		var apiErr apiError
+		if err := json.Unmarshal(rr.Body.Bytes(), &apiErr); err != nil {
+			t.Fatalf("%s %s: Expected json error, got %s", test.method, test.path, rr.Body.String())
+		}
+		if apiErr.Code != test.code || apiErr.Message == "" || apiErr.RequestID != "req-42" {
+			t.Errorf("%s %s: Expected %s with request id, got %+v", test.method, test.path, test.code, apiErr)
+		}
+		if test.details != nil && fmt.Sprint(apiErr.Details) != fmt.Sprint(test.details) {
+			t.Errorf("%s %s: Expected details %v, got %v", test.method, test.path, test.details, apiErr.Details)
+		}
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	cfg := defaultConfig()
+	store, err := newStore(cfg)
+	if err != nil || store == nil {
+		t.Errorf("Expected servicenow store, got %v %v", store, err)
+	}
+
+	cfg.Store = "unknown"
+	_, err = newStore(cfg)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestIncidentHandler(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name:   "Fake",
+		Report: []incidentsStore.Incident{{Number: "INC1235", State: "Open"}},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	// success case
+	req := httptest.NewRequest("GET", "/api/v1/incidents/INC1235", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"number":"INC1235","assigned_to":"","description":"","state":"Open","priority":"","severity":""}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// failure cases - unknown incident and bad paths
+	for _, path := range []string{"/api/v1/incidents/INC0000", "/api/v1/incidents/", "/api/v1/incidents/INC1235/x"} {
+		req = httptest.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusNotFound)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Expected json error body, got %s", path, ct)
+		}
+	}
+
+	// failure case - method not allowed
+	req = httptest.NewRequest("POST", "/api/v1/incidents/INC1235", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow request is in flight when the signal arrives
+	started := make(chan struct{})
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	store := &fakeStore{}
+	signals := make(chan os.Signal, 1)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(httpServer, func() error { return httpServer.Serve(ln) }, store, time.Second, signals)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	if b := <-body; b != "done" {
+		t.Errorf("Expected in-flight request to finish, got %v", b)
+	}
+	if !store.closed {
+		t.Errorf("Expected store to be closed")
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	store := &fakeStore{}
+	err := serve(&http.Server{}, func() error { return errors.New("address in use") },
+		store, time.Second, make(chan os.Signal))
+	if err == nil || !store.closed {
+		t.Errorf("Expected error and closed store, got %v %v", err, store.closed)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// group_by used when the parameter is missing
+var defaultGroupBy = []string{"priority"}
+
+// incidentsSummary is the response of the summary endpoint
+type incidentsSummary struct {
+	GroupBy []string       `json:"group_by"`
+	Total   int            `json:"total"`
+	Groups  []summaryGroup `json:"groups"`
+}
+
+// summaryGroup is the number of incidents sharing the values of the group_by fields
+type summaryGroup struct {
+	Values map[string]string `json:"values"`
+	Count  int               `json:"count"`
+}
+
+// parseGroupBy validates the comma separated group_by fields
+func parseGroupBy(param string) ([]string, error) {
+	if param == "" {
+		return defaultGroupBy, nil
+	}
+	var groupBy []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := filterFields[field]; !ok {
+			return nil, fmt.Errorf("invalid group_by field %q", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("duplicate group_by field %q", field)
+		}
+		seen[field] = true
+		groupBy = append(groupBy, field)
+	}
+	return groupBy, nil
+}
+
+/*
+summarize counts the incidents per combination of the groupBy field values
+Groups are ordered by the field values, enumerated fields by their rank
+*/
+func summarize(report []incidentsStore.Incident, groupBy []string) *incidentsSummary {
+	// each group keeps its first incident, it is used to order the groups
+	type group struct {
+		first incidentsStore.Incident
+		summaryGroup
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+
+	for _, inc := range report {
+		values := make(map[string]string, len(groupBy))
+		var key []string
+		for _, field := range groupBy {
+			values[field] = filterFields[field].value(inc)
+			key = append(key, values[field])
+		}
+		k := strings.Join(key, "\x00")
+		if g, ok := byKey[k]; ok {
+			g.Count++
+			continue
+		}
+		g := &group{inc, summaryGroup{Values: values, Count: 1}}
+		byKey[k] = g
+		groups = append(groups, g)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		for _, field := range groupBy {
+			if c := sortFields[field](groups[i].first, groups[j].first); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	summary := &incidentsSummary{GroupBy: groupBy, Total: len(report), Groups: []summaryGroup{}}
+	for _, g := range groups {
+		summary.Groups = append(summary.Groups, g.summaryGroup)
+	}
+	return summary
+}
+
+// summaryHandler serves GET /api/v1/incidents/summary?group_by=priority,severity
+// The list filters narrow down the incidents being counted
+func (s *IncidentServer) summaryHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	groupBy, err := parseGroupBy(query.Get("group_by"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	query.Del("group_by")
+	for param := range pageParams {
+		if _, ok := query[param]; ok {
+			writeError(w, http.StatusBadRequest, "invalid_query", param+" is not supported by the summary")
+			return
+		}
+	}
+
+	match, err := parseFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	incidents, err := s.store.Query(match)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	s.writeCacheable(w, r, summarize(incidents.Report, groupBy))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"craftDemoServer/incidentsStore"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	groupBy, err := parseGroupBy("")
+	if err != nil || len(groupBy) != 1 || groupBy[0] != "priority" {
+		t.Errorf("Expected default priority, got %v %v", groupBy, err)
+	}
+	groupBy, err = parseGroupBy("priority, severity")
+	if err != nil || len(groupBy) != 2 || groupBy[1] != "severity" {
+		t.Errorf("Expected priority and severity, got %v %v", groupBy, err)
+	}
+
+	// failure cases
+	for _, param := range []string{"color", "priority,priority", "priority,"} {
+		if _, err := parseGroupBy(param); err == nil {
+			t.Errorf("%s: Expected error, got nil", param)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	report := []incidentsStore.Incident{
+		{Number: "INC1", Priority: "Low", Severity: "High"},
+		{Number: "INC2", Priority: "Critical", Severity: "High"},
+		{Number: "INC3", Priority: "High", Severity: "Low"},
+		{Number: "INC4", Priority: "Critical", Severity: "High"},
+		{Number: "INC5", Priority: "High", Severity: "High"},
+	}
+
+	summary := summarize(report, []string{"priority", "severity"})
+	expected := []struct {
+		priority string
+		severity string
+		count    int
+	}{
+		{"Critical", "High", 2},
+		{"High", "High", 1},
+		{"High", "Low", 1},
+		{"Low", "High", 1},
+	}
+	if summary.Total != 5 || len(summary.Groups) != len(expected) {
+		t.Fatalf("Expected total 5 in %d groups, got %+v", len(expected), summary)
+	}
+	for i, e := range expected {
+		g := summary.Groups[i]
+		if g.Values["priority"] != e.priority || g.Values["severity"] != e.severity || g.Count != e.count {
+			t.Errorf("Expected %+v, got %+v", e, g)
+		}
+	}
+
+	// no incidents
+	summary = summarize(nil, []string{"state"})
+	if summary.Total != 0 || len(summary.Groups) != 0 {
+		t.Errorf("Expected empty summary, got %+v", summary)
+	}
+}
+
+func TestSummaryHandler(t *testing.T) {
+	store := &fakeStore{incidents: incidentsStore.Incidents{
+		Name: "Fake",
+		Report: []incidentsStore.Incident{
+			{Number: "INC1", State: "Open", Priority: "High", Severity: "Low"},
+			{Number: "INC2", State: "Open", Priority: "High", Severity: "High"},
+			{Number: "INC3", State: "Closed", Priority: "High", Severity: "High"},
+		},
+	}}
+	handler := NewIncidentServer(store).Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/incidents/summary?state=Open", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"group_by":["priority"],"total":2,"groups":[{"values":{"priority":"High"},"count":2}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	var summary incidentsSummary
+	req = httptest.NewRequest("GET", "/api/v1/incidents/summary?group_by=severity", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &summary)
+	if len(summary.Groups) != 2 || summary.Groups[0].Values["severity"] != "High" || summary.Groups[0].Count != 2 {
+		t.Errorf("Expected High severity first with 2 incidents, got %+v", summary)
+	}
+
+	// failure cases
+	for _, path := range []string{
+		"/api/v1/incidents/summary?group_by=color",
+		"/api/v1/incidents/summary?state=Done",
+		"/api/v1/incidents/summary?limit=1",
+	} {
+		req = httptest.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				path, status, http.StatusBadRequest)
+		}
+	}
+}
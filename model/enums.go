@@ -0,0 +1,140 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// State of an incident in its lifecycle
+type State string
+
+// Priority of an incident
+type Priority string
+
+// Severity of an incident
+type Severity string
+
+const (
+	StateNew        State = "New"
+	StateOpen       State = "Open"
+	StateInProgress State = "In Progress"
+	StateBlocked    State = "Blocked"
+	StateResolved   State = "Resolved"
+	StateClosed     State = "Closed"
+)
+
+const (
+	PriorityCritical Priority = "Critical"
+	PriorityHigh     Priority = "High"
+	PriorityMedium   Priority = "Medium"
+	PriorityLow      Priority = "Low"
+)
+
+const (
+	SeverityCritical Severity = "Critical"
+	SeverityHigh     Severity = "High"
+	SeverityMedium   Severity = "Medium"
+	SeverityLow      Severity = "Low"
+)
+
+// Allowed values of the enumerated incident fields, in rank order
+var (
+	States = []string{string(StateNew), string(StateOpen), string(StateInProgress),
+		string(StateBlocked), string(StateResolved), string(StateClosed)}
+	Priorities = []string{string(PriorityCritical), string(PriorityHigh), string(PriorityMedium), string(PriorityLow)}
+	Severities = []string{string(SeverityCritical), string(SeverityHigh), string(SeverityMedium), string(SeverityLow)}
+)
+
+/*
+canonical returns the allowed spelling of v, e.g. high -> High
+Values are matched case insensitively, anything else is a ValidationError of field
+*/
+func canonical(field string, allowed []string, v string) (string, error) {
+	for _, a := range allowed {
+		if strings.EqualFold(a, v) {
+			return a, nil
+		}
+	}
+	return "", &ValidationError{field, fmt.Sprintf("%q is not one of %s", v, strings.Join(allowed, ", "))}
+}
+
+// unmarshalEnum decodes a json string into its allowed spelling, empty or null means unset
+func unmarshalEnum(field string, allowed []string, data []byte) (string, error) {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", &ValidationError{field, "must be a string"}
+	}
+	if v == "" {
+		return "", nil
+	}
+	return canonical(field, allowed, v)
+}
+
+// marshalEnum encodes v in its allowed spelling, empty means unset
+func marshalEnum(field string, allowed []string, v string) ([]byte, error) {
+	if v != "" {
+		var err error
+		if v, err = canonical(field, allowed, v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(v)
+}
+
+// ParseState returns the state spelled v in any case, unknown values are a ValidationError
+func ParseState(v string) (State, error) {
+	s, err := canonical("state", States, v)
+	return State(s), err
+}
+
+// ParsePriority returns the priority spelled v in any case, unknown values are a ValidationError
+func ParsePriority(v string) (Priority, error) {
+	p, err := canonical("priority", Priorities, v)
+	return Priority(p), err
+}
+
+// ParseSeverity returns the severity spelled v in any case, unknown values are a ValidationError
+func ParseSeverity(v string) (Severity, error) {
+	s, err := canonical("severity", Severities, v)
+	return Severity(s), err
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	return marshalEnum("state", States, string(s))
+}
+
+func (s *State) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("state", States, data)
+	if err != nil {
+		return err
+	}
+	*s = State(v)
+	return nil
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return marshalEnum("priority", Priorities, string(p))
+}
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("priority", Priorities, data)
+	if err != nil {
+		return err
+	}
+	*p = Priority(v)
+	return nil
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return marshalEnum("severity", Severities, string(s))
+}
+
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("severity", Severities, data)
+	if err != nil {
+		return err
+	}
+	*s = Severity(v)
+	return nil
+}
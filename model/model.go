@@ -0,0 +1,158 @@
+/*
+Package model holds the incident types shared by the client and the server,
+so both sides agree on the json schema and the allowed values
+*/
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ValidationError is returned when an incident does not match the schema
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+// Entire Incidents object
+type Incidents struct {
+	Name   string     `json:"Name"`
+	Report []Incident `json:"Report"`
+}
+
+// Individual incident object
+// History and Revision are kept by the server and left out of the client tables
+type Incident struct {
+	Number      string       `json:"number"`
+	AssignedTo  string       `json:"assigned_to"`
+	Description string       `json:"description"`
+	State       State        `json:"state"`
+	Priority    Priority     `json:"priority"`
+	Severity    Severity     `json:"severity"`
+	History     []Transition `json:"history,omitempty" table:"-"`
+	Revision    int          `json:"revision,omitempty" table:"-"` // bumped by the store on every write
+}
+
+// Transition records a state change of an incident, who made it and when
+type Transition struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	By   string    `json:"by"`
+	At   time.Time `json:"at"`
+}
+
+// State every incident is created in
+const InitialState = StateNew
+
+/*
+Lifecycle of an incident, state -> states it can move to
+New -> Open -> In Progress -> Resolved -> Closed
+In Progress can be Blocked and resumed, Resolved and Closed incidents can be reopened
+*/
+var transitions = map[State][]State{
+	StateNew:        {StateOpen},
+	StateOpen:       {StateInProgress, StateResolved},
+	StateInProgress: {StateBlocked, StateResolved},
+	StateBlocked:    {StateInProgress},
+	StateResolved:   {StateClosed, StateOpen},
+	StateClosed:     {StateOpen},
+}
+
+// TransitionError is returned for state changes the lifecycle does not allow
+type TransitionError struct {
+	Number  string
+	From    State
+	To      State
+	Allowed []State
+}
+
+func (e *TransitionError) Error() string {
+	if e.From == "" {
+		return fmt.Sprintf("new incidents start in %q, not %q", InitialState, e.To)
+	}
+	if len(e.Allowed) == 0 {
+		return fmt.Sprintf("incident %s can not move from %q to %q", e.Number, e.From, e.To)
+	}
+	allowed := make([]string, len(e.Allowed))
+	for i, s := range e.Allowed {
+		allowed[i] = string(s)
+	}
+	return fmt.Sprintf("incident %s can not move from %q to %q, allowed states are %s",
+		e.Number, e.From, e.To, strings.Join(allowed, ", "))
+}
+
+/*
+Transition moves the incident to state to, if the lifecycle allows it,
+and appends the change to its history
+Moving to the current state is a no-op
+*/
+func (inc *Incident) Transition(to State, by string, at time.Time) error {
+	canonical, err := ParseState(string(to))
+	if err != nil {
+		return err
+	}
+	if canonical == inc.State {
+		return nil
+	}
+
+	allowed, ok := transitions[inc.State]
+	// a new incident has no state yet and can only start in InitialState
+	if inc.State == "" {
+		allowed, ok = []State{InitialState}, true
+	}
+	permitted := false
+	for _, v := range allowed {
+		if v == canonical {
+			permitted = true
+		}
+	}
+	if !ok || !permitted {
+		return &TransitionError{Number: inc.Number, From: inc.State, To: canonical, Allowed: allowed}
+	}
+
+	// copy the history, it may be shared with the stored incident
+	history := make([]Transition, len(inc.History), len(inc.History)+1)
+	copy(history, inc.History)
+	inc.History = append(history, Transition{From: inc.State, To: canonical, By: by, At: at})
+	inc.State = canonical
+	return nil
+}
+
+// incident numbers look like INC1234
+var numberFormat = regexp.MustCompile(`^INC[0-9]+$`)
+
+/*
+Validate checks the incident against the schema before it is written
+Number is optional (stores allocate it), description is mandatory and
+the enumerated fields must hold allowed values.
+Enumerated values are canonicalized to their allowed spelling, e.g. high -> High
+*/
+func (inc *Incident) Validate() error {
+	if inc.Number != "" && !numberFormat.MatchString(inc.Number) {
+		return &ValidationError{"number", fmt.Sprintf("%q does not look like INCnnnn", inc.Number)}
+	}
+	if strings.TrimSpace(inc.Description) == "" {
+		return &ValidationError{"description", "must not be empty"}
+	}
+	state, err := ParseState(string(inc.State))
+	if err != nil {
+		return err
+	}
+	priority, err := ParsePriority(string(inc.Priority))
+	if err != nil {
+		return err
+	}
+	severity, err := ParseSeverity(string(inc.Severity))
+	if err != nil {
+		return err
+	}
+	inc.State, inc.Priority, inc.Severity = state, priority, severity
+	return nil
+}
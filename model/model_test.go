@@ -0,0 +1,127 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	// success case - enumerated values are canonicalized
+	inc := Incident{Number: "INC1234", Description: "Login is not working",
+		State: "in progress", Priority: "HIGH", Severity: "low"}
+	if err := inc.Validate(); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	if inc.State != "In Progress" || inc.Priority != "High" || inc.Severity != "Low" {
+		t.Errorf("Expected canonical values, got %+v", inc)
+	}
+
+	// failure cases
+	tests := []struct {
+		inc   Incident
+		field string
+	}{
+		{Incident{Number: "1234", Description: "a", State: "Open", Priority: "High", Severity: "High"}, "number"},
+		{Incident{Description: " ", State: "Open", Priority: "High", Severity: "High"}, "description"},
+		{Incident{Description: "a", State: "Done", Priority: "High", Severity: "High"}, "state"},
+		{Incident{Description: "a", State: "Open", Priority: "", Severity: "High"}, "priority"},
+		{Incident{Description: "a", State: "Open", Priority: "High", Severity: "Huge"}, "severity"},
+	}
+	for _, test := range tests {
+		err := test.inc.Validate()
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("Expected ValidationError, got %v", err)
+			continue
+		}
+		if verr.Field != test.field {
+			t.Errorf("Expected invalid %s, got %v", test.field, verr)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	at := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	inc := Incident{Number: "INC1"}
+
+	// walk the whole lifecycle, including a reopen
+	for _, state := range []State{"New", "open", "In Progress", "Blocked", "In Progress", "Resolved", "Open", "Resolved", "Closed", "Open"} {
+		if err := inc.Transition(state, "tom", at); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	}
+	if inc.State != "Open" || len(inc.History) != 10 {
+		t.Errorf("Expected Open with 10 transitions, got %v %v", inc.State, len(inc.History))
+	}
+	if h := inc.History[1]; h.From != "New" || h.To != "Open" || h.By != "tom" || !h.At.Equal(at) {
+		t.Errorf("Expected New -> Open by tom, got %+v", h)
+	}
+
+	// moving to the current state is a no-op
+	if err := inc.Transition("Open", "tom", at); err != nil || len(inc.History) != 10 {
+		t.Errorf("Expected no-op, got %v", err)
+	}
+
+	// failure cases
+	if _, ok := inc.Transition("Closed", "tom", at).(*TransitionError); !ok {
+		t.Errorf("Expected TransitionError for Open -> Closed")
+	}
+	if _, ok := inc.Transition("Done", "tom", at).(*ValidationError); !ok {
+		t.Errorf("Expected ValidationError for unknown state")
+	}
+	if inc.State != "Open" || len(inc.History) != 10 {
+		t.Errorf("Expected unchanged incident, got %+v", inc)
+	}
+	created := Incident{}
+	if _, ok := created.Transition("Open", "tom", at).(*TransitionError); !ok {
+		t.Errorf("Expected TransitionError for a new incident not starting in New")
+	}
+
+	// the history is copied, not shared with the original incident
+	copied := inc
+	copied.Transition("In Progress", "tom", at)
+	if len(inc.History) != 10 {
+		t.Errorf("Expected original history to be unchanged, got %v", len(inc.History))
+	}
+}
+
+func TestIncidentJSON(t *testing.T) {
+	// success case - enumerated values are canonicalized, empty values are unset
+	var inc Incident
+	err := json.Unmarshal([]byte(`{"number":"INC1","state":"in progress","priority":"HIGH","severity":""}`), &inc)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if inc.State != StateInProgress || inc.Priority != PriorityHigh || inc.Severity != "" {
+		t.Errorf("Expected canonical values, got %+v", inc)
+	}
+	js, err := json.Marshal(inc)
+	expected := `{"number":"INC1","assigned_to":"","description":"","state":"In Progress","priority":"High","severity":""}`
+	if err != nil || string(js) != expected {
+		t.Errorf("Expected %s, got %s %v", expected, js, err)
+	}
+
+	// failure cases - unknown values are rejected both ways
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{`{"state":"Done"}`, "state"},
+		{`{"priority":"Urgent"}`, "priority"},
+		{`{"severity":"Huge"}`, "severity"},
+		{`{"severity":3}`, "severity"},
+		{`{"history":[{"from":"New","to":"Done"}]}`, "state"},
+	}
+	for _, test := range tests {
+		inc := Incident{Priority: PriorityLow}
+		err := json.Unmarshal([]byte(test.body), &inc)
+		verr, ok := err.(*ValidationError)
+		if !ok || verr.Field != test.field {
+			t.Errorf("%s: Expected invalid %s, got %v", test.body, test.field, err)
+		}
+	}
+	if _, err := json.Marshal(Incident{Priority: "Urgent"}); err == nil {
+		t.Errorf("Expected error for an unknown priority, got nil")
+	}
+}